@@ -0,0 +1,66 @@
+package main
+
+import (
+	"net/http"
+	"sync"
+	"sync/atomic"
+
+	"github.com/gorilla/mux"
+)
+
+// inflight tracks requests currently being served, overall and per route.
+var inflight struct {
+	total   atomic.Int64
+	mu      sync.Mutex
+	byRoute map[string]*atomic.Int64
+}
+
+func inflightRoute(route string) *atomic.Int64 {
+	inflight.mu.Lock()
+	defer inflight.mu.Unlock()
+	if inflight.byRoute == nil {
+		inflight.byRoute = make(map[string]*atomic.Int64)
+	}
+	counter, ok := inflight.byRoute[route]
+	if !ok {
+		counter = new(atomic.Int64)
+		inflight.byRoute[route] = counter
+	}
+	return counter
+}
+
+// trackInflight counts a request as in flight until its handler returns;
+// the decrement is deferred so a panicking handler is still accounted for.
+func trackInflight(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		route := r.URL.Path
+		if current := mux.CurrentRoute(r); current != nil {
+			if template, err := current.GetPathTemplate(); err == nil {
+				route = template
+			}
+		}
+		counter := inflightRoute(r.Method + " " + route)
+		inflight.total.Add(1)
+		counter.Add(1)
+		defer func() {
+			counter.Add(-1)
+			inflight.total.Add(-1)
+		}()
+		next.ServeHTTP(w, r)
+	})
+}
+
+func returnInflight(w http.ResponseWriter, r *http.Request) {
+	byRoute := map[string]int64{}
+	inflight.mu.Lock()
+	for route, counter := range inflight.byRoute {
+		if n := counter.Load(); n > 0 {
+			byRoute[route] = n
+		}
+	}
+	inflight.mu.Unlock()
+	writeJSON(w, r, http.StatusOK, struct {
+		Total   int64            `json:"total"`
+		ByRoute map[string]int64 `json:"by_route"`
+	}{Total: inflight.total.Load(), ByRoute: byRoute})
+}
@@ -0,0 +1,22 @@
+package main
+
+import (
+	"net/http"
+	"strings"
+)
+
+// features records every optional endpoint group consulted during route
+// registration and whether it ended up mounted.
+var features = map[string]bool{}
+
+// featureEnabled reports whether the named feature should be mounted. The
+// FEATURE_<NAME> env var (e.g. FEATURE_BULK=false) overrides the default.
+func featureEnabled(name string, enabledByDefault bool) bool {
+	enabled := envBool("FEATURE_"+strings.ToUpper(name), enabledByDefault)
+	features[name] = enabled
+	return enabled
+}
+
+func returnFeatures(w http.ResponseWriter, r *http.Request) {
+	writeJSON(w, r, http.StatusOK, features)
+}
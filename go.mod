@@ -1,5 +1,24 @@
 module github.com/mr-meetpatel/go-crud-api
 
-go 1.19
+go 1.22
 
-require github.com/gorilla/mux v1.8.0
+require (
+	github.com/andybalholm/brotli v1.2.5
+	github.com/golang-jwt/jwt/v5 v5.2.1
+	github.com/google/uuid v1.6.0
+	github.com/gorilla/mux v1.8.0
+	github.com/prometheus/client_golang v1.19.1
+	golang.org/x/crypto v0.31.0
+	golang.org/x/text v0.21.0
+	golang.org/x/time v0.5.0
+)
+
+require (
+	github.com/beorn7/perks v1.0.1 // indirect
+	github.com/cespare/xxhash/v2 v2.2.0 // indirect
+	github.com/prometheus/client_model v0.5.0 // indirect
+	github.com/prometheus/common v0.48.0 // indirect
+	github.com/prometheus/procfs v0.12.0 // indirect
+	golang.org/x/sys v0.28.0 // indirect
+	google.golang.org/protobuf v1.33.0 // indirect
+)
@@ -0,0 +1,150 @@
+package main
+
+import (
+	"context"
+	"log"
+	"net/http"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+const healthCheckTimeout = 2 * time.Second
+
+var startedAt = time.Now()
+
+// shuttingDown is set once a shutdown signal arrives, turning /ready to 503
+// while requests already routed here are still served.
+var shuttingDown atomic.Bool
+
+// returnHealth is the liveness probe for load balancers. It touches no
+// dependencies, so it stays green while a component is merely degraded;
+// use /health/detailed for those.
+func returnHealth(w http.ResponseWriter, r *http.Request) {
+	writeJSON(w, r, http.StatusOK, struct {
+		Status string `json:"status"`
+		Uptime string `json:"uptime"`
+	}{Status: "ok", Uptime: time.Since(startedAt).Round(time.Second).String()})
+}
+
+// healthCheck is one component reported by /health/detailed. A failing
+// critical component makes the service unhealthy; a failing non-critical
+// one only degrades it.
+type healthCheck struct {
+	name     string
+	critical bool
+	check    func(ctx context.Context) error
+}
+
+type ComponentStatus struct {
+	Status    string  `json:"status"`
+	Critical  bool    `json:"critical"`
+	LatencyMs float64 `json:"latency_ms"`
+	Error     string  `json:"error,omitempty"`
+}
+
+func healthChecks() []healthCheck {
+	var checks []healthCheck
+	if webhookURL != "" {
+		checks = append(checks, healthCheck{name: "webhook", check: checkWebhookReachable})
+	}
+	return checks
+}
+
+// checkWebhookReachable only verifies that the target answers HTTP; any
+// status code counts as reachable.
+func checkWebhookReachable(ctx context.Context) error {
+	req, err := http.NewRequestWithContext(ctx, http.MethodHead, webhookURL, nil)
+	if err != nil {
+		return err
+	}
+	resp, err := webhookClient.Do(req)
+	if err != nil {
+		return err
+	}
+	resp.Body.Close()
+	return nil
+}
+
+// runHealthChecks runs checks concurrently, each under healthCheckTimeout,
+// and reports every component by name.
+func runHealthChecks(ctx context.Context, checks []healthCheck) map[string]ComponentStatus {
+	components := make(map[string]ComponentStatus, len(checks))
+	var mu sync.Mutex
+	var wg sync.WaitGroup
+	for _, hc := range checks {
+		wg.Add(1)
+		go func(hc healthCheck) {
+			defer wg.Done()
+			ctx, cancel := context.WithTimeout(ctx, healthCheckTimeout)
+			defer cancel()
+			start := time.Now()
+			err := hc.check(ctx)
+			component := ComponentStatus{
+				Status:    "up",
+				Critical:  hc.critical,
+				LatencyMs: float64(time.Since(start).Microseconds()) / 1000,
+			}
+			if err != nil {
+				log.Printf("health check %s failed: %v", hc.name, err)
+				component.Status, component.Error = "down", "check failed"
+			}
+			mu.Lock()
+			components[hc.name] = component
+			mu.Unlock()
+		}(hc)
+	}
+	wg.Wait()
+	return components
+}
+
+// overallHealth is "down" (503) if a critical component is down, "degraded"
+// if only non-critical ones are, and "ok" otherwise.
+func overallHealth(components map[string]ComponentStatus) (string, int) {
+	overall := "ok"
+	for _, component := range components {
+		if component.Status == "up" {
+			continue
+		}
+		if component.Critical {
+			return "down", http.StatusServiceUnavailable
+		}
+		overall = "degraded"
+	}
+	return overall, http.StatusOK
+}
+
+func returnDetailedHealth(w http.ResponseWriter, r *http.Request) {
+	components := runHealthChecks(r.Context(), healthChecks())
+	overall, status := overallHealth(components)
+	writeJSON(w, r, status, struct {
+		Status     string                     `json:"status"`
+		Components map[string]ComponentStatus `json:"components"`
+	}{Status: overall, Components: components})
+}
+
+// returnReady is the readiness probe: 503 while any critical component is
+// down or once shutdown has begun, so traffic is routed elsewhere without the
+// instance being restarted. Non-critical components do not affect readiness.
+func returnReady(w http.ResponseWriter, r *http.Request) {
+	type readiness struct {
+		Status string `json:"status"`
+	}
+	if shuttingDown.Load() {
+		writeJSON(w, r, http.StatusServiceUnavailable, readiness{Status: "unavailable"})
+		return
+	}
+	var critical []healthCheck
+	for _, hc := range healthChecks() {
+		if hc.critical {
+			critical = append(critical, hc)
+		}
+	}
+	for _, component := range runHealthChecks(r.Context(), critical) {
+		if component.Status != "up" {
+			writeJSON(w, r, http.StatusServiceUnavailable, readiness{Status: "unavailable"})
+			return
+		}
+	}
+	writeJSON(w, r, http.StatusOK, readiness{Status: "ready"})
+}
@@ -0,0 +1,117 @@
+package main
+
+import (
+	"fmt"
+	"net/http"
+	"sync"
+	"time"
+
+	"github.com/gorilla/mux"
+)
+
+// articleLock is an advisory editing lock on one article. While it is held,
+// updates from anyone but its owner are refused with 423 Locked.
+type articleLock struct {
+	Owner     string    `json:"owner"`
+	ExpiresAt time.Time `json:"expires_at"`
+}
+
+// lockTTL is how long a lock lasts; acquiring it again renews it.
+var lockTTL = 5 * time.Minute
+
+var (
+	locksMu      sync.Mutex
+	articleLocks = map[string]articleLock{}
+)
+
+// lockKey identifies an article across tenants. Tenant ids cannot contain
+// "/", so the key is unambiguous.
+func lockKey(r *http.Request, id string) string {
+	return tenantId(r) + "/" + id
+}
+
+// lockOwner is the subject of the request's JWT, or "" when there is none.
+// Basic auth and API keys are shared credentials, so they cannot tell two
+// editors apart.
+func lockOwner(r *http.Request) string {
+	subject, _ := requestClaims(r).GetSubject()
+	return subject
+}
+
+// activeLock returns the unexpired lock stored under key, dropping an expired
+// one. The caller must hold locksMu.
+func activeLock(key string) (articleLock, bool) {
+	lock, ok := articleLocks[key]
+	if ok && !time.Now().Before(lock.ExpiresAt) {
+		delete(articleLocks, key)
+		return articleLock{}, false
+	}
+	return lock, ok
+}
+
+func writeLocked(w http.ResponseWriter, r *http.Request, lock articleLock) {
+	writeError(w, r, http.StatusLocked, fmt.Sprintf("Article is locked by %s until %s", lock.Owner, lock.ExpiresAt.UTC().Format(time.RFC3339)))
+}
+
+func lockArticle(w http.ResponseWriter, r *http.Request) {
+	articleId := mux.Vars(r)["id"]
+	owner := lockOwner(r)
+	if owner == "" {
+		writeError(w, r, http.StatusForbidden, "Locking requires a bearer token with a subject")
+		return
+	}
+	if _, ok := findArticle(r, articleId); !ok {
+		writeError(w, r, http.StatusNotFound, "Article not found")
+		return
+	}
+	key := lockKey(r, articleId)
+	locksMu.Lock()
+	if lock, locked := activeLock(key); locked && lock.Owner != owner {
+		locksMu.Unlock()
+		writeLocked(w, r, lock)
+		return
+	}
+	lock := articleLock{Owner: owner, ExpiresAt: time.Now().Add(lockTTL)}
+	articleLocks[key] = lock
+	locksMu.Unlock()
+	writeJSON(w, r, http.StatusOK, lock)
+}
+
+func unlockArticle(w http.ResponseWriter, r *http.Request) {
+	owner := lockOwner(r)
+	if owner == "" {
+		writeError(w, r, http.StatusForbidden, "Locking requires a bearer token with a subject")
+		return
+	}
+	key := lockKey(r, mux.Vars(r)["id"])
+	locksMu.Lock()
+	lock, locked := activeLock(key)
+	switch {
+	case !locked:
+		locksMu.Unlock()
+		writeError(w, r, http.StatusNotFound, "Article is not locked")
+		return
+	case lock.Owner != owner:
+		locksMu.Unlock()
+		writeLocked(w, r, lock)
+		return
+	}
+	delete(articleLocks, key)
+	locksMu.Unlock()
+	w.WriteHeader(http.StatusNoContent)
+}
+
+// requireLockOwner refuses updates to an article that someone else has
+// locked.
+func requireLockOwner(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		locksMu.Lock()
+		lock, locked := activeLock(lockKey(r, mux.Vars(r)["id"]))
+		locksMu.Unlock()
+		if locked && lock.Owner != lockOwner(r) {
+			writeLocked(w, r, lock)
+			return
+		}
+		next.ServeHTTP(w, r)
+	})
+}
@@ -0,0 +1,72 @@
+package main
+
+import (
+	"net/http"
+	"testing"
+	"time"
+
+	"github.com/golang-jwt/jwt/v5"
+)
+
+// bearer returns an Authorization header value for subject, signed with the
+// test secret.
+func bearer(t *testing.T, subject string) string {
+	t.Helper()
+	token, err := jwt.NewWithClaims(jwt.SigningMethodHS256, jwt.MapClaims{"sub": subject}).SignedString([]byte("s3cret"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	return "Bearer " + token
+}
+
+func TestArticleLocks(t *testing.T) {
+	h := testHandler(t, "JWT_SECRET", "s3cret")
+	withArticles(t, sampleArticles...)
+	setConfig(t, &articleLocks, map[string]articleLock{})
+	alice, bob := bearer(t, "alice"), bearer(t, "bob")
+	patch := `{"desc":"edited"}`
+
+	var lock articleLock
+	decode(t, serve(h, "POST", "/articles/1/lock", "", "Authorization", alice), &lock)
+	if lock.Owner != "alice" || !lock.ExpiresAt.After(time.Now()) {
+		t.Fatalf("acquire: got %+v", lock)
+	}
+	for _, tc := range []struct {
+		name, method, path, body string
+	}{
+		{"lock", "POST", "/articles/1/lock", ""},
+		{"unlock", "DELETE", "/articles/1/lock", ""},
+		{"patch", "PATCH", "/articles/1", patch},
+		{"put", "PUT", "/articles/1", `{"title":"Taken over","content":"c"}`},
+	} {
+		if rec := serve(h, tc.method, tc.path, tc.body, "Authorization", bob); rec.Code != http.StatusLocked {
+			t.Errorf("%s by another editor: got %d, want 423", tc.name, rec.Code)
+		}
+	}
+	if rec := serve(h, "PATCH", "/articles/1", patch, "Authorization", alice); rec.Code != http.StatusOK {
+		t.Errorf("patch by the owner: got %d %s", rec.Code, rec.Body)
+	}
+	if rec := serve(h, "PATCH", "/articles/2", patch, "Authorization", bob); rec.Code != http.StatusOK {
+		t.Errorf("patch of an unlocked article: got %d", rec.Code)
+	}
+	if rec := serve(h, "DELETE", "/articles/1/lock", "", "Authorization", alice); rec.Code != http.StatusNoContent {
+		t.Errorf("unlock by the owner: got %d", rec.Code)
+	}
+	if rec := serve(h, "PATCH", "/articles/1", patch, "Authorization", bob); rec.Code != http.StatusOK {
+		t.Errorf("patch after unlock: got %d", rec.Code)
+	}
+
+	setConfig(t, &lockTTL, time.Millisecond)
+	serve(h, "POST", "/articles/1/lock", "", "Authorization", alice)
+	time.Sleep(5 * time.Millisecond)
+	if rec := serve(h, "POST", "/articles/1/lock", "", "Authorization", bob); rec.Code != http.StatusOK {
+		t.Errorf("acquire after the lock expired: got %d", rec.Code)
+	}
+
+	if rec := serve(h, "POST", "/articles/404/lock", "", "Authorization", alice); rec.Code != http.StatusNotFound {
+		t.Errorf("lock of a missing article: got %d, want 404", rec.Code)
+	}
+	if rec := serve(h, "POST", "/articles/2/lock", "", "Authorization", bearer(t, "")); rec.Code != http.StatusForbidden {
+		t.Errorf("lock without a subject: got %d, want 403", rec.Code)
+	}
+}
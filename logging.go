@@ -0,0 +1,90 @@
+package main
+
+import (
+	"log"
+	"log/slog"
+	"net/http"
+	"os"
+	"strings"
+	"time"
+)
+
+// configureLogging installs the default slog logger. format is "text"
+// (default) or "json"; level is debug, info (default), warn or error. The
+// standard log package is routed through the same handler by slog.SetDefault,
+// at error level since it is only used for failures here.
+func configureLogging(format, level string) {
+	var lvl slog.Level
+	if level != "" {
+		if err := lvl.UnmarshalText([]byte(level)); err != nil {
+			log.Fatalf("invalid LOG_LEVEL %q: expected debug, info, warn or error", level)
+		}
+	}
+	opts := &slog.HandlerOptions{Level: lvl}
+	var handler slog.Handler
+	switch strings.ToLower(format) {
+	case "", "text":
+		handler = slog.NewTextHandler(os.Stderr, opts)
+	case "json":
+		handler = slog.NewJSONHandler(os.Stderr, opts)
+	default:
+		log.Fatalf("invalid LOG_FORMAT %q: expected text or json", format)
+	}
+	slog.SetDefault(slog.New(handler))
+	slog.SetLogLoggerLevel(slog.LevelError)
+}
+
+// statusRecorder remembers the status and body size a handler wrote.
+type statusRecorder struct {
+	http.ResponseWriter
+	status int
+	size   int
+}
+
+func (sr *statusRecorder) WriteHeader(status int) {
+	if sr.status == 0 {
+		sr.status = status
+	}
+	sr.ResponseWriter.WriteHeader(status)
+}
+
+func (sr *statusRecorder) Write(b []byte) (int, error) {
+	if sr.status == 0 {
+		sr.status = http.StatusOK
+	}
+	n, err := sr.ResponseWriter.Write(b)
+	sr.size += n
+	return n, err
+}
+
+func (sr *statusRecorder) Flush() {
+	if flusher, ok := sr.ResponseWriter.(http.Flusher); ok {
+		flusher.Flush()
+	}
+}
+
+// logRequests emits one line per request once it completes. Server errors
+// are logged at error level, everything else at info. size is the number
+// of bytes sent, after compression.
+func logRequests(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		start := time.Now()
+		sr := &statusRecorder{ResponseWriter: w}
+		next.ServeHTTP(sr, r)
+		if sr.status == 0 {
+			sr.status = http.StatusOK
+		}
+		level := slog.LevelInfo
+		if sr.status >= 500 {
+			level = slog.LevelError
+		}
+		slog.LogAttrs(r.Context(), level, "request",
+			slog.String("request_id", requestId(r)),
+			slog.String("method", r.Method),
+			slog.String("path", r.URL.Path),
+			slog.Int("status", sr.status),
+			slog.Int("size", sr.size),
+			slog.Duration("duration", time.Since(start)),
+		)
+	})
+}
@@ -1,12 +1,43 @@
 package main
 
 import (
+	"bytes"
+	"context"
 	"encoding/json"
+	"errors"
 	"fmt"
+	"io"
 	"io/ioutil"
+	"log"
+	"log/slog"
 	"net/http"
+	"os"
+	"os/signal"
+	"reflect"
+	"regexp"
+	"slices"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"syscall"
+	"time"
+	"unicode"
+	"unicode/utf8"
 
 	"github.com/gorilla/mux"
+	"github.com/prometheus/client_golang/prometheus/promhttp"
+	"golang.org/x/text/unicode/norm"
+)
+
+const (
+	maxTitleLength   = 255
+	maxExistsIds     = 1000
+	exportFlushEvery = 100
+	maxSuggestions   = 10
+	defaultPageLimit = 20
+	maxPageLimit     = 100
+	maxBatchSize     = 1000
 )
 
 type Article struct {
@@ -14,105 +45,1314 @@ type Article struct {
 	Title   string `json:"title"`
 	Desc    string `json:"desc"`
 	Content string `json:"content"`
+	// Position is the curated order set through PUT /articles/order, starting
+	// at 1; zero means the article has not been placed.
+	Position int `json:"position,omitempty"`
+	// TenantId scopes the article in multi-tenant mode; it is never serialized.
+	TenantId string `json:"-"`
+}
+
+// ArticlePatch holds the fields of a PATCH body; nil means the field was
+// omitted and keeps its stored value, while "" clears it.
+type ArticlePatch struct {
+	Id      *string `json:"id"`
+	Title   *string `json:"title"`
+	Desc    *string `json:"desc"`
+	Content *string `json:"content"`
+}
+
+// ArticleChanges is the response to PATCH ?return=changed: only the fields the
+// update actually changed are present.
+type ArticleChanges struct {
+	Title   *string `json:"title,omitempty"`
+	Desc    *string `json:"desc,omitempty"`
+	Content *string `json:"content,omitempty"`
+}
+
+// ArticlePreview is a list entry whose content may be cut short by ?preview=true.
+type ArticlePreview struct {
+	Article
+	Truncated bool `json:"truncated"`
+}
+
+// PaginatedResponse is the body of GET /articles: one page of results plus
+// the total number of matching articles.
+type PaginatedResponse struct {
+	Data   interface{} `json:"data"`
+	Total  int         `json:"total"`
+	Limit  int         `json:"limit"`
+	Offset int         `json:"offset"`
 }
 
 type CustomError struct {
-	Message string `json:"message"`
+	Message   string `json:"message"`
+	RequestId string `json:"request_id,omitempty"`
+}
+
+// InternalError is the only body clients see for a 500; the underlying error
+// is logged with the request id instead of being returned.
+type InternalError struct {
+	Message   string `json:"message"`
+	Code      string `json:"code"`
+	RequestId string `json:"request_id,omitempty"`
+}
+
+// Envelope wraps a response body when the client asks for ?envelope=true.
+type Envelope struct {
+	Data      interface{} `json:"data"`
+	RequestId string      `json:"request_id,omitempty"`
+	Timestamp time.Time   `json:"timestamp"`
+}
+
+type ValidationError struct {
+	Key   string `json:"key"`
+	Error string `json:"error"`
+}
+
+// BatchError reports the validation errors of one element of a batch request.
+type BatchError struct {
+	Index  int               `json:"index"`
+	Errors []ValidationError `json:"errors"`
+}
+
+// Articles is the store; every handler reads it under articlesMu.RLock and
+// changes it under articlesMu.Lock.
+var (
+	Articles   []Article
+	articlesMu sync.RWMutex
+)
+
+// backgroundGroup tracks the workers started with run so shutdown can wait
+// for them. Shutdown closes draining first: workers finish what is already
+// queued and return. ctx is cancelled only if they are still running at the
+// deadline, which aborts whatever they are doing.
+type backgroundGroup struct {
+	ctx      context.Context
+	cancel   context.CancelFunc
+	draining chan struct{}
+	wg       sync.WaitGroup
+}
+
+var background = newBackgroundGroup()
+
+func newBackgroundGroup() *backgroundGroup {
+	ctx, cancel := context.WithCancel(context.Background())
+	return &backgroundGroup{ctx: ctx, cancel: cancel, draining: make(chan struct{})}
+}
+
+func (g *backgroundGroup) run(fn func(ctx context.Context, draining <-chan struct{})) {
+	g.wg.Add(1)
+	go func() {
+		defer g.wg.Done()
+		fn(g.ctx, g.draining)
+	}()
+}
+
+// shutdown signals the workers to drain and waits up to timeout for them to
+// return before cancelling them. It returns once every worker has exited.
+func (g *backgroundGroup) shutdown(timeout time.Duration) {
+	close(g.draining)
+	done := make(chan struct{})
+	go func() {
+		g.wg.Wait()
+		close(done)
+	}()
+	select {
+	case <-done:
+	case <-time.After(timeout):
+		slog.Warn("background work did not drain in time, cancelling", "timeout", timeout)
+		g.cancel()
+		<-done
+	}
+	g.cancel()
+}
+
+// emptyCollectionStatus is what returnAllArticles answers with when no articles
+// match: 200 with an empty page (the default, set EMPTY_COLLECTION_STATUS=200)
+// or 204 No Content (EMPTY_COLLECTION_STATUS=204).
+var emptyCollectionStatus = http.StatusOK
+
+// titleUniqueness controls whether two articles may share a title:
+// "" (default) allows duplicates, "sensitive" rejects exact matches and
+// "insensitive" also treats "Go" and "go" as the same title.
+var titleUniqueness string
+
+// previewLength is how many characters of content ?preview=true keeps (PREVIEW_LENGTH, default 200).
+var previewLength = 200
+
+// legacyValidationErrors keeps the old bare-array validation error body for
+// clients that have not migrated to {"errors":[...]} (LEGACY_VALIDATION_ERRORS=true).
+var legacyValidationErrors bool
+
+// batchDecoding is how POST /articles/batch reads its body (BATCH_DECODING):
+// "stream" (the default) decodes the array one element at a time and stops
+// reading as soon as it exceeds maxBatchSize, so an oversized import is never
+// held in memory; "buffered" reads the whole body before decoding it.
+var batchDecoding = "stream"
+
+var errBatchTooLarge = errors.New("too many articles in batch")
+
+// decodeBatch reads a JSON array of articles using batchDecoding.
+func decodeBatch(body io.Reader) ([]Article, error) {
+	if batchDecoding == "buffered" {
+		payload, _ := ioutil.ReadAll(body)
+		var articles []Article
+		if err := json.Unmarshal(payload, &articles); err != nil {
+			return nil, err
+		}
+		if len(articles) > maxBatchSize {
+			return nil, errBatchTooLarge
+		}
+		return articles, nil
+	}
+	decoder := json.NewDecoder(body)
+	if token, err := decoder.Token(); err != nil || token != json.Delim('[') {
+		return nil, errors.New("expected an array")
+	}
+	articles := []Article{}
+	for decoder.More() {
+		if len(articles) == maxBatchSize {
+			return nil, errBatchTooLarge
+		}
+		var article Article
+		if err := decoder.Decode(&article); err != nil {
+			return nil, err
+		}
+		articles = append(articles, article)
+	}
+	if _, err := decoder.Token(); err != nil {
+		return nil, err
+	}
+	if _, err := decoder.Token(); err != io.EOF {
+		return nil, errors.New("unexpected data after the array")
+	}
+	return articles, nil
+}
+
+// normalizeTitle trims surrounding whitespace and converts the title to NFC so
+// visually identical titles ("Café " vs "Cafe\u0301") are stored identically.
+func normalizeTitle(title string) string {
+	return norm.NFC.String(strings.TrimSpace(title))
+}
+
+func sameTitle(a, b string, ignoreCase bool) bool {
+	return a == b || (ignoreCase && strings.EqualFold(a, b))
+}
+
+// titleExists must be called with articlesMu held.
+func titleExists(tenant, title, exceptId string, ignoreCase bool) bool {
+	for _, article := range Articles {
+		if article.TenantId == tenant && article.Id != exceptId && sameTitle(article.Title, title, ignoreCase) {
+			return true
+		}
+	}
+	return false
+}
+
+func titleTaken(tenant, title, exceptId string) bool {
+	return titleUniqueness != "" && titleExists(tenant, title, exceptId, titleUniqueness == "insensitive")
+}
+
+// previewContent shortens content to at most length characters, backing up
+// to the previous word boundary rather than cutting a word in half.
+func previewContent(content string, length int) (string, bool) {
+	runes := []rune(content)
+	if len(runes) <= length {
+		return content, false
+	}
+	cut := string(runes[:length])
+	if i := strings.LastIndexFunc(cut, unicode.IsSpace); i > 0 && !unicode.IsSpace(runes[length]) {
+		cut = cut[:i]
+	}
+	return strings.TrimRightFunc(cut, unicode.IsSpace), true
+}
+
+// validateArticle is shared by every write path, so an article can never be
+// blanked out through PUT or PATCH that could not have been created that way.
+func validateArticle(article Article) []ValidationError {
+	var errs []ValidationError
+	if article.Title == "" {
+		errs = append(errs, ValidationError{Key: "title", Error: "is required"})
+	} else if utf8.RuneCountInString(article.Title) > maxTitleLength {
+		errs = append(errs, ValidationError{Key: "title", Error: fmt.Sprintf("must be at most %d characters", maxTitleLength)})
+	}
+	if strings.TrimSpace(article.Content) == "" {
+		errs = append(errs, ValidationError{Key: "content", Error: "is required"})
+	}
+	return errs
+}
+
+// wantsCamelCase reports whether the client asked for camelCase keys via
+// ?case=camel or X-Key-Case: camel. Keys default to the snake_case JSON tags;
+// only those are converted, never the keys of map data.
+func wantsCamelCase(r *http.Request) bool {
+	keyCase := r.URL.Query().Get("case")
+	if keyCase == "" {
+		keyCase = r.Header.Get("X-Key-Case")
+	}
+	return strings.EqualFold(keyCase, "camel")
+}
+
+func snakeToCamel(key string) string {
+	parts := strings.Split(key, "_")
+	for i := 1; i < len(parts); i++ {
+		if parts[i] != "" {
+			parts[i] = strings.ToUpper(parts[i][:1]) + parts[i][1:]
+		}
+	}
+	return strings.Join(parts, "")
+}
+
+// camelCaseValue converts the keys of decoded, the JSON encoding of source,
+// walking both together so that only keys taken from struct fields are
+// renamed. Keys of Go maps are data, such as feature names or routes, and are
+// left as they are.
+func camelCaseValue(decoded interface{}, source reflect.Value) interface{} {
+	for source.Kind() == reflect.Pointer || source.Kind() == reflect.Interface {
+		if source.IsNil() {
+			return decoded
+		}
+		source = source.Elem()
+	}
+	switch value := decoded.(type) {
+	case map[string]interface{}:
+		switch source.Kind() {
+		case reflect.Struct:
+			converted := make(map[string]interface{}, len(value))
+			for key, item := range value {
+				converted[snakeToCamel(key)] = camelCaseValue(item, jsonField(source, key))
+			}
+			return converted
+		case reflect.Map:
+			for key, item := range value {
+				var elem reflect.Value
+				if source.Type().Key().Kind() == reflect.String {
+					elem = source.MapIndex(reflect.ValueOf(key).Convert(source.Type().Key()))
+				}
+				value[key] = camelCaseValue(item, elem)
+			}
+		}
+		return value
+	case []interface{}:
+		for i, item := range value {
+			var elem reflect.Value
+			if (source.Kind() == reflect.Slice || source.Kind() == reflect.Array) && i < source.Len() {
+				elem = source.Index(i)
+			}
+			value[i] = camelCaseValue(item, elem)
+		}
+		return value
+	default:
+		return decoded
+	}
+}
+
+// jsonField returns the field of the struct v that encoding/json writes as
+// key, looking through embedded structs, or the zero Value if there is none.
+func jsonField(v reflect.Value, key string) reflect.Value {
+	for i := 0; i < v.NumField(); i++ {
+		field := v.Type().Field(i)
+		name, _, _ := strings.Cut(field.Tag.Get("json"), ",")
+		if field.Anonymous && name == "" && field.Type.Kind() == reflect.Struct {
+			if found := jsonField(v.Field(i), key); found.IsValid() {
+				return found
+			}
+			continue
+		}
+		if !field.IsExported() || name == "-" {
+			continue
+		}
+		if name == "" {
+			name = field.Name
+		}
+		if name == key {
+			return v.Field(i)
+		}
+	}
+	return reflect.Value{}
+}
+
+func camelCaseKeys(body []byte, source interface{}) ([]byte, error) {
+	decoder := json.NewDecoder(bytes.NewReader(body))
+	decoder.UseNumber()
+	var decoded interface{}
+	if err := decoder.Decode(&decoded); err != nil {
+		return nil, err
+	}
+	return json.Marshal(camelCaseValue(decoded, reflect.ValueOf(source)))
+}
+
+func writeJSON(w http.ResponseWriter, r *http.Request, status int, v interface{}) {
+	if envelope, _ := queryBool(r, "envelope"); envelope {
+		v = Envelope{Data: v, RequestId: requestId(r), Timestamp: time.Now().UTC()}
+	}
+	body, err := json.Marshal(v)
+	if err == nil && wantsCamelCase(r) {
+		body, err = camelCaseKeys(body, v)
+	}
+	if err != nil {
+		writeInternalError(w, r, fmt.Errorf("encoding response: %w", err))
+		return
+	}
+	body = append(body, '\n')
+	w.Header().Set("Content-Type", "application/json")
+	w.Header().Set("Content-Length", strconv.Itoa(len(body)))
+	w.WriteHeader(status)
+	if r.Method != http.MethodHead {
+		w.Write(body)
+	}
+}
+
+func writeValidationErrors(w http.ResponseWriter, r *http.Request, errs []ValidationError) {
+	if legacyValidationErrors {
+		writeJSON(w, r, http.StatusBadRequest, errs)
+		return
+	}
+	writeJSON(w, r, http.StatusBadRequest, struct {
+		Errors []ValidationError `json:"errors"`
+	}{Errors: errs})
+}
+
+func writeInternalError(w http.ResponseWriter, r *http.Request, err error) {
+	log.Printf("internal error: request_id=%q %s %s: %v", requestId(r), r.Method, r.URL.Path, err)
+	writeJSON(w, r, http.StatusInternalServerError, InternalError{
+		Message:   "internal server error",
+		Code:      "INTERNAL",
+		RequestId: requestId(r),
+	})
+}
+
+func writeError(w http.ResponseWriter, r *http.Request, status int, message string) {
+	writeJSON(w, r, status, CustomError{Message: message, RequestId: requestId(r)})
+}
+
+// parseBool accepts true/false, 1/0 and yes/no in any case, so a typo such as
+// "ture" is reported instead of silently read as false.
+func parseBool(value string) (bool, error) {
+	switch strings.ToLower(value) {
+	case "true", "1", "yes":
+		return true, nil
+	case "false", "0", "no":
+		return false, nil
+	}
+	return false, fmt.Errorf("invalid boolean %q: expected true, false, 1, 0, yes or no", value)
+}
+
+// queryBool parses the named query parameter with parseBool; absent means false.
+func queryBool(r *http.Request, name string) (bool, error) {
+	value := r.URL.Query().Get(name)
+	if value == "" {
+		return false, nil
+	}
+	parsed, err := parseBool(value)
+	if err != nil {
+		return false, fmt.Errorf("%s: %w", name, err)
+	}
+	return parsed, nil
+}
+
+func splitList(value string) []string {
+	var items []string
+	for _, item := range strings.Split(value, ",") {
+		if item = strings.TrimSpace(item); item != "" {
+			items = append(items, item)
+		}
+	}
+	return items
+}
+
+func envFloat(key string, fallback float64) float64 {
+	value := os.Getenv(key)
+	if value == "" {
+		return fallback
+	}
+	parsed, err := strconv.ParseFloat(value, 64)
+	if err != nil || parsed < 0 {
+		log.Fatalf("invalid %s %q: must be a non-negative number", key, value)
+	}
+	return parsed
+}
+
+func envBool(key string, fallback bool) bool {
+	value := os.Getenv(key)
+	if value == "" {
+		return fallback
+	}
+	parsed, err := strconv.ParseBool(value)
+	if err != nil {
+		log.Fatalf("invalid %s %q: must be true or false", key, value)
+	}
+	return parsed
+}
+
+func envInt(key string, fallback int) int {
+	value := os.Getenv(key)
+	if value == "" {
+		return fallback
+	}
+	parsed, err := strconv.Atoi(value)
+	if err != nil || parsed < 0 {
+		log.Fatalf("invalid %s %q: must be a non-negative integer", key, value)
+	}
+	return parsed
 }
 
-var Articles []Article
+// envDuration parses key as a time.ParseDuration string such as "30s".
+func envDuration(key string, fallback time.Duration) time.Duration {
+	value := os.Getenv(key)
+	if value == "" {
+		return fallback
+	}
+	parsed, err := time.ParseDuration(value)
+	if err != nil || parsed <= 0 {
+		log.Fatalf("invalid %s %q: must be a positive duration such as 30s", key, value)
+	}
+	return parsed
+}
 
 func homePage(w http.ResponseWriter, r *http.Request) {
 	fmt.Fprintf(w, "Welcome to home page")
 }
 
 func returnAllArticles(w http.ResponseWriter, r *http.Request) {
-	fmt.Println("Endpoint Hit: returnAllArticles")
-	w.Header().Set("Content-Type", "application/json")
-	json.NewEncoder(w).Encode(Articles)
+	limit, offset, err := pageParams(r)
+	if err != nil {
+		writeError(w, r, http.StatusBadRequest, err.Error())
+		return
+	}
+	preview, err := queryBool(r, "preview")
+	if err != nil {
+		writeError(w, r, http.StatusBadRequest, err.Error())
+		return
+	}
+	articles := filterByTitle(tenantArticles(r), r.URL.Query().Get("title"))
+	if err := sortArticles(r, articles); err != nil {
+		writeError(w, r, http.StatusBadRequest, err.Error())
+		return
+	}
+	if len(articles) == 0 && emptyCollectionStatus == http.StatusNoContent {
+		w.WriteHeader(http.StatusNoContent)
+		return
+	}
+	response := PaginatedResponse{Total: len(articles), Limit: limit, Offset: offset}
+	articles = paginate(articles, limit, offset)
+	if preview {
+		previews := make([]ArticlePreview, len(articles))
+		for i, article := range articles {
+			previews[i].Article = article
+			previews[i].Content, previews[i].Truncated = previewContent(article.Content, previewLength)
+		}
+		response.Data = previews
+	} else {
+		response.Data = articles
+	}
+	writeJSON(w, r, http.StatusOK, response)
 }
 
-func returnSingleArticle(w http.ResponseWriter, r *http.Request) {
-	articleId := mux.Vars(r)["id"]
-	fmt.Println("Endpoint Hit: returnAllArticles")
-	w.Header().Set("Content-Type", "application/json")
+// pageParams reads ?limit= and ?offset=, defaulting to defaultPageLimit and 0
+// and capping limit at maxPageLimit.
+func pageParams(r *http.Request) (limit, offset int, err error) {
+	limit, offset = defaultPageLimit, 0
+	query := r.URL.Query()
+	if value := query.Get("limit"); value != "" {
+		if limit, err = strconv.Atoi(value); err != nil || limit < 0 {
+			return 0, 0, fmt.Errorf("limit must be a non-negative integer")
+		}
+	}
+	if value := query.Get("offset"); value != "" {
+		if offset, err = strconv.Atoi(value); err != nil || offset < 0 {
+			return 0, 0, fmt.Errorf("offset must be a non-negative integer")
+		}
+	}
+	if limit > maxPageLimit {
+		limit = maxPageLimit
+	}
+	return limit, offset, nil
+}
+
+// filterByTitle keeps the articles whose title contains title, ignoring case.
+// The term is matched literally, so characters like "%" have no special meaning.
+func filterByTitle(articles []Article, title string) []Article {
+	if title == "" {
+		return articles
+	}
+	title = strings.ToLower(title)
+	filtered := []Article{}
+	for _, article := range articles {
+		if strings.Contains(strings.ToLower(article.Title), title) {
+			filtered = append(filtered, article)
+		}
+	}
+	return filtered
+}
+
+func paginate(articles []Article, limit, offset int) []Article {
+	if offset >= len(articles) {
+		return []Article{}
+	}
+	articles = articles[offset:]
+	if limit < len(articles) {
+		articles = articles[:limit]
+	}
+	return articles
+}
+
+// sortByPosition orders placed articles by position, followed by unplaced
+// ones in their stored order.
+func sortByPosition(articles []Article, descending bool) {
+	sort.SliceStable(articles, func(i, j int) bool {
+		a, b := articles[i].Position, articles[j].Position
+		if a == 0 || b == 0 {
+			return b == 0 && a != 0
+		}
+		if descending {
+			return a > b
+		}
+		return a < b
+	})
+}
+
+// sortById orders articles by id, numerically when both ids are numbers so
+// that "10" follows "9".
+func sortById(articles []Article) {
+	sort.SliceStable(articles, func(i, j int) bool {
+		a, errA := strconv.Atoi(articles[i].Id)
+		b, errB := strconv.Atoi(articles[j].Id)
+		if errA == nil && errB == nil {
+			return a < b
+		}
+		return articles[i].Id < articles[j].Id
+	})
+}
+
+// sortArticles applies ?sort= to articles. Descending order can be asked for
+// with a leading "-" (?sort=-position) or with ?order=desc; ?order= only
+// accepts asc or desc and must not contradict the prefix.
+func sortArticles(r *http.Request, articles []Article) error {
+	sortBy, order := r.URL.Query().Get("sort"), strings.ToLower(r.URL.Query().Get("order"))
+	descending := strings.HasPrefix(sortBy, "-")
+	sortBy = strings.TrimPrefix(sortBy, "-")
+	switch order {
+	case "":
+	case "asc":
+		if descending {
+			return fmt.Errorf("order=asc conflicts with descending sort %q", "-"+sortBy)
+		}
+	case "desc":
+		descending = true
+	default:
+		return fmt.Errorf("Unsupported order %q, expected asc or desc", order)
+	}
+	switch sortBy {
+	case "":
+		if descending || order != "" {
+			return fmt.Errorf("order requires a sort field")
+		}
+	case "position":
+		sortByPosition(articles, descending)
+	default:
+		return fmt.Errorf("Unsupported sort %q, expected position", sortBy)
+	}
+	return nil
+}
+
+func reorderArticles(w http.ResponseWriter, r *http.Request) {
+	payload, _ := ioutil.ReadAll(r.Body)
+	var ids []string
+	if err := json.Unmarshal(payload, &ids); err != nil {
+		writeError(w, r, http.StatusBadRequest, "invalid JSON body, expected an array of ids")
+		return
+	}
+	tenant := tenantId(r)
+	positions := make(map[string]int, len(ids))
+	for i, id := range ids {
+		if _, duplicate := positions[id]; duplicate {
+			writeError(w, r, http.StatusBadRequest, fmt.Sprintf("Article %q is listed more than once", id))
+			return
+		}
+		positions[id] = i + 1
+	}
+	articlesMu.Lock()
+	// Stored ids are not guaranteed unique, so count each listed id once.
+	matched := make(map[string]bool, len(positions))
 	for _, article := range Articles {
-		if article.Id == articleId {
-			json.NewEncoder(w).Encode(article)
+		if article.TenantId == tenant && positions[article.Id] != 0 {
+			matched[article.Id] = true
+		}
+	}
+	if len(matched) != len(positions) {
+		articlesMu.Unlock()
+		writeError(w, r, http.StatusBadRequest, "Order contains unknown article ids")
+		return
+	}
+	// Articles left out of the list lose their place, so positions always
+	// reflect exactly the latest ordering without gaps or ties.
+	for index, article := range Articles {
+		if article.TenantId == tenant {
+			Articles[index].Position = positions[article.Id]
+		}
+	}
+	articlesMu.Unlock()
+	articles := tenantArticles(r)
+	sortByPosition(articles, false)
+	writeJSON(w, r, http.StatusOK, articles)
+}
+
+func countArticles(w http.ResponseWriter, r *http.Request) {
+	articles := filterByTitle(tenantArticles(r), r.URL.Query().Get("title"))
+	writeJSON(w, r, http.StatusOK, struct {
+		Count int `json:"count"`
+	}{Count: len(articles)})
+}
+
+func exportArticles(w http.ResponseWriter, r *http.Request) {
+	if format := r.URL.Query().Get("format"); format != "" && format != "ndjson" {
+		writeError(w, r, http.StatusBadRequest, "Unsupported export format, expected ndjson")
+		return
+	}
+	w.Header().Set("Content-Type", "application/x-ndjson")
+	flusher, _ := w.(http.Flusher)
+	encoder := json.NewEncoder(w)
+	for i, article := range tenantArticles(r) {
+		if err := encoder.Encode(article); err != nil {
+			log.Printf("exporting articles: %v", err)
 			return
 		}
+		if flusher != nil && (i+1)%exportFlushEvery == 0 {
+			flusher.Flush()
+		}
+	}
+}
+
+// suggestArticles returns up to maxSuggestions id/title pairs for a search
+// box: titles starting with q come before titles merely containing it, and
+// newer articles come first within each group.
+func suggestArticles(w http.ResponseWriter, r *http.Request) {
+	type suggestion struct {
+		Id    string `json:"id"`
+		Title string `json:"title"`
+	}
+	query := strings.ToLower(strings.TrimSpace(r.URL.Query().Get("q")))
+	prefixed, containing := []suggestion{}, []suggestion{}
+	if query != "" {
+		articles := tenantArticles(r)
+		for i := len(articles) - 1; i >= 0; i-- {
+			title := strings.ToLower(articles[i].Title)
+			if strings.HasPrefix(title, query) {
+				prefixed = append(prefixed, suggestion{Id: articles[i].Id, Title: articles[i].Title})
+			} else if strings.Contains(title, query) {
+				containing = append(containing, suggestion{Id: articles[i].Id, Title: articles[i].Title})
+			}
+		}
+	}
+	suggestions := append(prefixed, containing...)
+	if len(suggestions) > maxSuggestions {
+		suggestions = suggestions[:maxSuggestions]
+	}
+	writeJSON(w, r, http.StatusOK, suggestions)
+}
+
+func searchTerms(text string) []string {
+	return strings.FieldsFunc(strings.ToLower(text), func(c rune) bool {
+		return !unicode.IsLetter(c) && !unicode.IsNumber(c)
+	})
+}
+
+// searchArticles returns the articles whose title and content contain every
+// word of q, ranked by how often those words occur.
+func searchArticles(w http.ResponseWriter, r *http.Request) {
+	terms := searchTerms(r.URL.Query().Get("q"))
+	type match struct {
+		article Article
+		rank    int
+	}
+	var matches []match
+	if len(terms) > 0 {
+		for _, article := range tenantArticles(r) {
+			counts := map[string]int{}
+			for _, word := range searchTerms(article.Title + " " + article.Content) {
+				counts[word]++
+			}
+			rank := 0
+			for _, term := range terms {
+				if counts[term] == 0 {
+					rank = 0
+					break
+				}
+				rank += counts[term]
+			}
+			if rank > 0 {
+				matches = append(matches, match{article: article, rank: rank})
+			}
+		}
+	}
+	sort.SliceStable(matches, func(i, j int) bool { return matches[i].rank > matches[j].rank })
+	results := make([]Article, len(matches))
+	for i, m := range matches {
+		results[i] = m.article
+	}
+	writeJSON(w, r, http.StatusOK, results)
+}
+
+// findArticle returns a copy of the request tenant's article with id.
+func findArticle(r *http.Request, id string) (Article, bool) {
+	articlesMu.RLock()
+	defer articlesMu.RUnlock()
+	for _, article := range Articles {
+		if article.Id == id && article.TenantId == tenantId(r) {
+			return article, true
+		}
 	}
-	w.WriteHeader(http.StatusNotFound)
-	json.NewEncoder(w).Encode(CustomError{Message: "Article not found"})
+	return Article{}, false
+}
+
+func returnSingleArticle(w http.ResponseWriter, r *http.Request) {
+	article, ok := findArticle(r, mux.Vars(r)["id"])
+	if !ok {
+		writeError(w, r, http.StatusNotFound, "Article not found")
+		return
+	}
+	writeJSON(w, r, http.StatusOK, article)
+}
+
+// returnArticleNeighbors returns the articles just before and after id by id,
+// or by the same ?sort= and ?order= as GET /articles when one is given.
+func returnArticleNeighbors(w http.ResponseWriter, r *http.Request) {
+	articleId := mux.Vars(r)["id"]
+	articles := tenantArticles(r)
+	sortById(articles)
+	if err := sortArticles(r, articles); err != nil {
+		writeError(w, r, http.StatusBadRequest, err.Error())
+		return
+	}
+	for index, article := range articles {
+		if article.Id != articleId {
+			continue
+		}
+		var neighbors struct {
+			Previous *Article `json:"previous"`
+			Next     *Article `json:"next"`
+		}
+		if index > 0 {
+			neighbors.Previous = &articles[index-1]
+		}
+		if index < len(articles)-1 {
+			neighbors.Next = &articles[index+1]
+		}
+		writeJSON(w, r, http.StatusOK, neighbors)
+		return
+	}
+	writeError(w, r, http.StatusNotFound, "Article not found")
+}
+
+func returnArticleContent(w http.ResponseWriter, r *http.Request) {
+	article, ok := findArticle(r, mux.Vars(r)["id"])
+	if !ok {
+		writeError(w, r, http.StatusNotFound, "Article not found")
+		return
+	}
+	w.Header().Set("Content-Type", "text/plain; charset=utf-8")
+	http.ServeContent(w, r, "", time.Time{}, strings.NewReader(article.Content))
+}
+
+var unsafeFilenameChars = regexp.MustCompile(`[^A-Za-z0-9._-]+`)
+
+func exportArticleMarkdown(w http.ResponseWriter, r *http.Request) {
+	article, ok := findArticle(r, mux.Vars(r)["id"])
+	if !ok {
+		writeError(w, r, http.StatusNotFound, "Article not found")
+		return
+	}
+	filename := "article-" + unsafeFilenameChars.ReplaceAllString(article.Id, "-") + ".md"
+	w.Header().Set("Content-Type", "text/markdown; charset=utf-8")
+	w.Header().Set("Content-Disposition", fmt.Sprintf("attachment; filename=%q", filename))
+	fmt.Fprintf(w, "# %s\n\n%s\n", article.Title, article.Content)
 }
 
 func createNewArticle(w http.ResponseWriter, r *http.Request) {
-	fmt.Println("Endpoint Hit: createNewArticle")
 	payload, _ := ioutil.ReadAll(r.Body)
 	var newArticle Article
-	json.Unmarshal(payload, &newArticle)
+	if err := json.Unmarshal(payload, &newArticle); err != nil {
+		writeError(w, r, http.StatusBadRequest, "invalid JSON body")
+		return
+	}
+	newArticle.Title = normalizeTitle(newArticle.Title)
+	newArticle.TenantId = tenantId(r)
+	newArticle.Position = 0
+	if errs := validateArticle(newArticle); len(errs) > 0 {
+		writeValidationErrors(w, r, errs)
+		return
+	}
+	articlesMu.Lock()
+	// If-None-Match: * asks us to create the article only if none with this
+	// title exists yet, giving clients a standard way to avoid duplicates.
+	if r.Header.Get("If-None-Match") == "*" && titleExists(newArticle.TenantId, newArticle.Title, "", titleUniqueness == "insensitive") {
+		articlesMu.Unlock()
+		writeError(w, r, http.StatusPreconditionFailed, "An article with this title already exists")
+		return
+	}
+	if titleTaken(newArticle.TenantId, newArticle.Title, "") {
+		articlesMu.Unlock()
+		writeError(w, r, http.StatusConflict, "An article with this title already exists")
+		return
+	}
 	Articles = append(Articles, newArticle)
-	w.Header().Set("Content-Type", "application/json")
-	w.WriteHeader(http.StatusCreated)
-	json.NewEncoder(w).Encode(newArticle)
+	articlesMu.Unlock()
+	notifyWebhook("article.created", newArticle)
+	writeJSON(w, r, http.StatusCreated, newArticle)
+}
+
+// createArticlesBatch validates every article before storing any of them, so
+// the batch is created as a whole or not at all.
+func createArticlesBatch(w http.ResponseWriter, r *http.Request) {
+	newArticles, err := decodeBatch(r.Body)
+	if errors.Is(err, errBatchTooLarge) {
+		writeError(w, r, http.StatusBadRequest, fmt.Sprintf("At most %d articles can be created at once", maxBatchSize))
+		return
+	}
+	if err != nil {
+		writeError(w, r, http.StatusBadRequest, "invalid JSON body, expected an array of articles")
+		return
+	}
+	tenant := tenantId(r)
+	articlesMu.Lock()
+	storedIds := make(map[string]bool)
+	for _, article := range Articles {
+		if article.TenantId == tenant {
+			storedIds[article.Id] = true
+		}
+	}
+	batchIds := make(map[string]bool, len(newArticles))
+	var batchErrors []BatchError
+	for index := range newArticles {
+		article := &newArticles[index]
+		article.Title = normalizeTitle(article.Title)
+		article.TenantId = tenant
+		article.Position = 0
+		errs := validateArticle(*article)
+		switch {
+		case article.Id == "":
+			errs = append(errs, ValidationError{Key: "id", Error: "is required"})
+		case storedIds[article.Id]:
+			errs = append(errs, ValidationError{Key: "id", Error: "an article with this id already exists"})
+		case batchIds[article.Id]:
+			errs = append(errs, ValidationError{Key: "id", Error: "is already used by an earlier article in this batch"})
+		}
+		batchIds[article.Id] = true
+		taken := titleTaken(tenant, article.Title, "")
+		for _, earlier := range newArticles[:index] {
+			if titleUniqueness != "" && sameTitle(earlier.Title, article.Title, titleUniqueness == "insensitive") {
+				taken = true
+			}
+		}
+		if taken {
+			errs = append(errs, ValidationError{Key: "title", Error: "an article with this title already exists"})
+		}
+		if len(errs) > 0 {
+			batchErrors = append(batchErrors, BatchError{Index: index, Errors: errs})
+		}
+	}
+	if len(batchErrors) > 0 {
+		articlesMu.Unlock()
+		writeJSON(w, r, http.StatusBadRequest, struct {
+			Errors []BatchError `json:"errors"`
+		}{Errors: batchErrors})
+		return
+	}
+	Articles = append(Articles, newArticles...)
+	articlesMu.Unlock()
+	for _, article := range newArticles {
+		notifyWebhook("article.created", article)
+	}
+	if newArticles == nil {
+		newArticles = []Article{}
+	}
+	writeJSON(w, r, http.StatusCreated, newArticles)
+}
+
+func deleteArticlesBatch(w http.ResponseWriter, r *http.Request) {
+	payload, _ := ioutil.ReadAll(r.Body)
+	var request struct {
+		Ids []string `json:"ids"`
+	}
+	if err := json.Unmarshal(payload, &request); err != nil {
+		writeError(w, r, http.StatusBadRequest, "invalid JSON body")
+		return
+	}
+	if len(request.Ids) > maxBatchSize {
+		writeError(w, r, http.StatusBadRequest, fmt.Sprintf("At most %d articles can be deleted at once", maxBatchSize))
+		return
+	}
+	requested := make(map[string]bool, len(request.Ids))
+	for _, id := range request.Ids {
+		requested[id] = true
+	}
+	tenant := tenantId(r)
+	articlesMu.Lock()
+	// kept is a new slice so copies of Articles taken before the lock are
+	// never overwritten in place.
+	kept := make([]Article, 0, len(Articles))
+	var deleted []Article
+	for _, article := range Articles {
+		if article.TenantId == tenant && requested[article.Id] {
+			deleted = append(deleted, article)
+		} else {
+			kept = append(kept, article)
+		}
+	}
+	Articles = kept
+	articlesMu.Unlock()
+	for _, article := range deleted {
+		notifyWebhook("article.deleted", article)
+	}
+	writeJSON(w, r, http.StatusOK, struct {
+		Requested int `json:"requested"`
+		Deleted   int `json:"deleted"`
+	}{Requested: len(requested), Deleted: len(deleted)})
 }
 
 func deleteArticleById(w http.ResponseWriter, r *http.Request) {
 	articleId := mux.Vars(r)["id"]
-	fmt.Println("Endpoint Hit: deleteArticleById")
-	w.Header().Set("Content-Type", "application/json")
+	articlesMu.Lock()
 	for index, article := range Articles {
 
-		if article.Id == articleId {
-			fmt.Println(Articles[:index], Articles[index+1:])
+		if article.Id == articleId && article.TenantId == tenantId(r) {
+			Articles = append(Articles[:index:index], Articles[index+1:]...)
+			articlesMu.Unlock()
 			w.WriteHeader(http.StatusNoContent)
-			Articles = append(Articles[:index], Articles[index+1:]...)
+			notifyWebhook("article.deleted", article)
 			return
 		}
 	}
-	w.WriteHeader(http.StatusNotFound)
-	json.NewEncoder(w).Encode(CustomError{Message: "Article not found"})
+	articlesMu.Unlock()
+	writeError(w, r, http.StatusNotFound, "Article not found")
 
 }
 func updateArticleById(w http.ResponseWriter, r *http.Request) {
 	articleId := mux.Vars(r)["id"]
-	fmt.Println("Endpoint Hit: updateArticle")
-	w.Header().Set("Content-Type", "application/json")
+	// The body is parsed before taking the lock, but an unknown id is still
+	// reported ahead of a bad body.
+	payload, _ := ioutil.ReadAll(r.Body)
+	var updatedArticle Article
+	parseErr := json.Unmarshal(payload, &updatedArticle)
+	articlesMu.Lock()
 	for index, article := range Articles {
-		if article.Id == articleId {
-			payload, _ := ioutil.ReadAll(r.Body)
-			var updatedArticle Article
-			json.Unmarshal(payload, &updatedArticle)
+		if article.Id == articleId && article.TenantId == tenantId(r) {
+			if parseErr != nil {
+				articlesMu.Unlock()
+				writeError(w, r, http.StatusBadRequest, "invalid JSON body")
+				return
+			}
+			if updatedArticle.Id != "" && updatedArticle.Id != articleId {
+				articlesMu.Unlock()
+				writeError(w, r, http.StatusBadRequest, "Body id does not match path id")
+				return
+			}
+			updatedArticle.Id = articleId
+			updatedArticle.TenantId = article.TenantId
+			updatedArticle.Position = article.Position
+			updatedArticle.Title = normalizeTitle(updatedArticle.Title)
+			if errs := validateArticle(updatedArticle); len(errs) > 0 {
+				articlesMu.Unlock()
+				writeValidationErrors(w, r, errs)
+				return
+			}
+			if titleTaken(updatedArticle.TenantId, updatedArticle.Title, articleId) {
+				articlesMu.Unlock()
+				writeError(w, r, http.StatusConflict, "An article with this title already exists")
+				return
+			}
 			Articles[index] = updatedArticle
-			json.NewEncoder(w).Encode(updatedArticle)
+			articlesMu.Unlock()
+			notifyWebhook("article.updated", updatedArticle)
+			writeJSON(w, r, http.StatusOK, updatedArticle)
 			return
 		}
 	}
-	w.WriteHeader(http.StatusNotFound)
+	articlesMu.Unlock()
 	// json.NewEncoder(w).Encode(struct {
 	// 	Message string `json:"message"`
 	// }{
 	// 	Message: "Article not found",
 	// })
-	json.NewEncoder(w).Encode(CustomError{Message: "Article not found"})
+	writeError(w, r, http.StatusNotFound, "Article not found")
 }
 
-func handleRequests() {
+// changedFields compares an article before and after an update.
+func changedFields(before, after Article) ArticleChanges {
+	var changes ArticleChanges
+	if after.Title != before.Title {
+		changes.Title = &after.Title
+	}
+	if after.Desc != before.Desc {
+		changes.Desc = &after.Desc
+	}
+	if after.Content != before.Content {
+		changes.Content = &after.Content
+	}
+	return changes
+}
+
+func updateArticlePartial(w http.ResponseWriter, r *http.Request) {
+	articleId := mux.Vars(r)["id"]
+	returnChanged := false
+	switch r.URL.Query().Get("return") {
+	case "":
+	case "changed":
+		returnChanged = true
+	default:
+		writeError(w, r, http.StatusBadRequest, "Unsupported return value, expected changed")
+		return
+	}
+	payload, _ := ioutil.ReadAll(r.Body)
+	var patch ArticlePatch
+	parseErr := json.Unmarshal(payload, &patch)
+	articlesMu.Lock()
+	for index, article := range Articles {
+		if article.Id == articleId && article.TenantId == tenantId(r) {
+			if parseErr != nil {
+				articlesMu.Unlock()
+				writeError(w, r, http.StatusBadRequest, "invalid JSON body")
+				return
+			}
+			if patch.Id != nil && *patch.Id != "" && *patch.Id != articleId {
+				articlesMu.Unlock()
+				writeError(w, r, http.StatusBadRequest, "Body id does not match path id")
+				return
+			}
+			before := article
+			if patch.Title != nil {
+				article.Title = normalizeTitle(*patch.Title)
+			}
+			if patch.Desc != nil {
+				article.Desc = *patch.Desc
+			}
+			if patch.Content != nil {
+				article.Content = *patch.Content
+			}
+			if errs := validateArticle(article); len(errs) > 0 {
+				articlesMu.Unlock()
+				writeValidationErrors(w, r, errs)
+				return
+			}
+			if titleTaken(article.TenantId, article.Title, articleId) {
+				articlesMu.Unlock()
+				writeError(w, r, http.StatusConflict, "An article with this title already exists")
+				return
+			}
+			Articles[index] = article
+			articlesMu.Unlock()
+			notifyWebhook("article.updated", article)
+			if returnChanged {
+				writeJSON(w, r, http.StatusOK, changedFields(before, article))
+				return
+			}
+			writeJSON(w, r, http.StatusOK, article)
+			return
+		}
+	}
+	articlesMu.Unlock()
+	writeError(w, r, http.StatusNotFound, "Article not found")
+}
+
+func checkArticlesExist(w http.ResponseWriter, r *http.Request) {
+	payload, _ := ioutil.ReadAll(r.Body)
+	var request struct {
+		Ids []string `json:"ids"`
+	}
+	if err := json.Unmarshal(payload, &request); err != nil {
+		writeError(w, r, http.StatusBadRequest, "invalid JSON body")
+		return
+	}
+	if len(request.Ids) > maxExistsIds {
+		writeError(w, r, http.StatusBadRequest, fmt.Sprintf("At most %d ids can be checked at once", maxExistsIds))
+		return
+	}
+	stored := make(map[string]bool)
+	for _, article := range tenantArticles(r) {
+		stored[article.Id] = true
+	}
+	result := struct {
+		Existing []string `json:"existing"`
+		Missing  []string `json:"missing"`
+	}{Existing: []string{}, Missing: []string{}}
+	seen := make(map[string]bool, len(request.Ids))
+	for _, id := range request.Ids {
+		if seen[id] {
+			continue
+		}
+		seen[id] = true
+		if stored[id] {
+			result.Existing = append(result.Existing, id)
+		} else {
+			result.Missing = append(result.Missing, id)
+		}
+	}
+	writeJSON(w, r, http.StatusOK, result)
+}
+
+// newHandler builds the router and wraps it in the middleware chain, reading
+// their configuration from the environment.
+func newHandler() http.Handler {
 	myRouter := mux.NewRouter().StrictSlash(true)
+	myRouter.Use(trackInflight, checkEnvelopeParam)
+	if envBool("MULTI_TENANT", false) {
+		myRouter.Use(tenantScope(splitList(os.Getenv("TENANTS"))))
+	}
+	myRouter.HandleFunc("/health", returnHealth).Methods("GET")
 	myRouter.HandleFunc("/", homePage).Methods("GET")
+	myRouter.HandleFunc("/features", returnFeatures).Methods("GET")
+	myRouter.HandleFunc("/health/detailed", returnDetailedHealth).Methods("GET")
+	myRouter.HandleFunc("/ready", returnReady).Methods("GET")
+	myRouter.Handle("/metrics", promhttp.Handler()).Methods("GET")
 	myRouter.HandleFunc("/articles", returnAllArticles).Methods("GET")
-	myRouter.HandleFunc("/articles/{id}", returnSingleArticle).Methods("GET")
-	myRouter.HandleFunc("/articles", createNewArticle).Methods("POST")
-	myRouter.HandleFunc("/articles/{id}", deleteArticleById).Methods("DELETE")
-	myRouter.HandleFunc("/articles/{id}", updateArticleById).Methods("PUT")
-	fmt.Printf("Server Start on port 8000")
-	http.ListenAndServe(":8000", myRouter)
+	if featureEnabled("export", true) {
+		myRouter.HandleFunc("/articles/export", exportArticles).Methods("GET")
+	}
+	myRouter.HandleFunc("/articles/count", countArticles).Methods("GET")
+	if featureEnabled("suggest", true) {
+		myRouter.HandleFunc("/articles/suggest", suggestArticles).Methods("GET")
+	}
+	if featureEnabled("search", true) {
+		myRouter.HandleFunc("/articles/search", searchArticles).Methods("GET")
+	}
+	myRouter.HandleFunc("/articles/{id}", returnSingleArticle).Methods("GET", "HEAD")
+	if featureEnabled("content", true) {
+		myRouter.HandleFunc("/articles/{id}/content", returnArticleContent).Methods("GET")
+	}
+	if featureEnabled("neighbors", true) {
+		myRouter.HandleFunc("/articles/{id}/neighbors", returnArticleNeighbors).Methods("GET")
+	}
+	if featureEnabled("markdown_export", true) {
+		myRouter.HandleFunc("/articles/{id}/export.md", exportArticleMarkdown).Methods("GET")
+	}
+	if featureEnabled("exists", true) {
+		myRouter.HandleFunc("/articles/exists", checkArticlesExist).Methods("POST")
+	}
+
+	// Every configured scheme must pass, and Basic and Bearer credentials both
+	// travel in the single Authorization header, so together they would
+	// reject every write.
+	if os.Getenv("BASIC_AUTH_USER") != "" && os.Getenv("JWT_SECRET") != "" {
+		log.Fatalf("BASIC_AUTH_USER and JWT_SECRET cannot both be set: both use the Authorization header")
+	}
+	withBasicAuth := basicAuth(os.Getenv("BASIC_AUTH_USER"), os.Getenv("BASIC_AUTH_PASS"))
+	withJWT := jwtAuth(os.Getenv("JWT_SECRET"))
+	apiKey := os.Getenv("API_KEY")
+	withAPIKey := apiKeyAuth(apiKey)
+	requireAuth := func(next http.Handler) http.Handler { return withBasicAuth(withJWT(withAPIKey(next))) }
+	myRouter.Handle("/articles", requireAuth(http.HandlerFunc(createNewArticle))).Methods("POST")
+	if featureEnabled("bulk", true) {
+		myRouter.Handle("/articles/batch", requireAuth(http.HandlerFunc(createArticlesBatch))).Methods("POST")
+		myRouter.Handle("/articles", requireAuth(http.HandlerFunc(deleteArticlesBatch))).Methods("DELETE")
+	}
+	myRouter.Handle("/articles/{id}", requireAuth(http.HandlerFunc(deleteArticleById))).Methods("DELETE")
+	myRouter.Handle("/articles/order", requireAuth(http.HandlerFunc(reorderArticles))).Methods("PUT")
+	myRouter.Handle("/articles/{id}", requireAuth(requireLockOwner(http.HandlerFunc(updateArticleById)))).Methods("PUT")
+	myRouter.Handle("/articles/{id}", requireAuth(requireLockOwner(http.HandlerFunc(updateArticlePartial)))).Methods("PATCH")
+	myRouter.Handle("/articles/{id}/lock", requireAuth(http.HandlerFunc(lockArticle))).Methods("POST")
+	myRouter.Handle("/articles/{id}/lock", requireAuth(http.HandlerFunc(unlockArticle))).Methods("DELETE")
+	// Debug endpoints are only mounted when an API key is configured to guard them.
+	if apiKey != "" {
+		debug := myRouter.PathPrefix("/debug").Subrouter()
+		debug.Use(withAPIKey)
+		debug.HandleFunc("/inflight", returnInflight).Methods("GET")
+	}
+	limiter := newRateLimiter(
+		envFloat("RATE_LIMIT_RPS", 0), envInt("RATE_LIMIT_BURST", 10),
+		envFloat("GLOBAL_RATE_LIMIT_RPS", 0), envInt("GLOBAL_RATE_LIMIT_BURST", 100),
+		envBool("TRUST_PROXY", false),
+	)
+	background.run(limiter.sweepIdle)
+	methods := splitList(os.Getenv("ALLOWED_METHODS"))
+	origins := splitList(os.Getenv("ALLOWED_ORIGINS"))
+	allowCredentials := envBool("CORS_ALLOW_CREDENTIALS", false)
+	// Credentials for every origin would let any site make requests with a
+	// visitor's cookies or auth headers and read the answers.
+	if allowCredentials && slices.Contains(origins, "*") {
+		log.Fatalf("ALLOWED_ORIGINS=* cannot be combined with CORS_ALLOW_CREDENTIALS: list the trusted origins instead")
+	}
+	return withRequestId(logRequests(recordMetrics(myRouter)(cors(origins, methods, allowCredentials)(
+		allowMethods(methods)(
+			limiter.middleware(compress(envInt("COMPRESS_MIN_SIZE", 1024))(myRouter)))))))
+}
+
+func handleRequests() {
+	handler := newHandler()
+	port := os.Getenv("PORT")
+	if port == "" {
+		port = "8000"
+	}
+	if n, err := strconv.Atoi(port); err != nil || n < 1 || n > 65535 {
+		log.Fatalf("invalid PORT %q: must be a number between 1 and 65535", port)
+	}
+	server := &http.Server{Addr: ":" + port, Handler: handler}
+	// Once a shutdown signal arrives, /ready fails for drainDelay while the
+	// server keeps serving, long enough for the orchestrator to notice and
+	// stop routing here. Then in-flight requests and background work each get
+	// shutdownTimeout to finish.
+	drainDelay := envDuration("SHUTDOWN_DRAIN_DELAY", 5*time.Second)
+	shutdownTimeout := envDuration("SHUTDOWN_TIMEOUT", 10*time.Second)
+	go func() {
+		slog.Info("server starting", "addr", server.Addr)
+		if err := server.ListenAndServe(); err != nil && !errors.Is(err, http.ErrServerClosed) {
+			log.Fatalf("server error: %v", err)
+		}
+	}()
+
+	ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt, syscall.SIGTERM)
+	defer stop()
+	<-ctx.Done()
+	// A second signal now stops the process without waiting.
+	stop()
+	shuttingDown.Store(true)
+	slog.Info("shutting down", "drain_delay", drainDelay, "timeout", shutdownTimeout)
+	time.Sleep(drainDelay)
+	shutdownCtx, cancel := context.WithTimeout(context.Background(), shutdownTimeout)
+	defer cancel()
+	if err := server.Shutdown(shutdownCtx); err != nil {
+		slog.Error("shutdown", "err", err)
+		server.Close()
+	}
+	background.shutdown(shutdownTimeout)
+}
+
+func loadConfig() {
+	configureLogging(os.Getenv("LOG_FORMAT"), os.Getenv("LOG_LEVEL"))
+	webhookURL = os.Getenv("WEBHOOK_URL")
+	legacyValidationErrors = envBool("LEGACY_VALIDATION_ERRORS", false)
+	lockTTL = envDuration("LOCK_TTL", lockTTL)
+	if previewLength = envInt("PREVIEW_LENGTH", previewLength); previewLength == 0 {
+		log.Fatalf("invalid PREVIEW_LENGTH: must be greater than zero")
+	}
+	switch titleUniqueness = os.Getenv("TITLE_UNIQUENESS"); titleUniqueness {
+	case "", "sensitive", "insensitive":
+	default:
+		log.Fatalf("invalid TITLE_UNIQUENESS %q: must be sensitive or insensitive", titleUniqueness)
+	}
+	switch decoding := os.Getenv("BATCH_DECODING"); decoding {
+	case "":
+	case "stream", "buffered":
+		batchDecoding = decoding
+	default:
+		log.Fatalf("invalid BATCH_DECODING %q: must be stream or buffered", decoding)
+	}
+	switch status := os.Getenv("EMPTY_COLLECTION_STATUS"); status {
+	case "", "200":
+		emptyCollectionStatus = http.StatusOK
+	case "204":
+		emptyCollectionStatus = http.StatusNoContent
+	default:
+		log.Fatalf("invalid EMPTY_COLLECTION_STATUS %q: must be 200 or 204", status)
+	}
 }
 
 func main() {
+	loadConfig()
 	Articles = []Article{
 		{Id: "1", Title: "Hello", Desc: "Article Description", Content: "Article Content"},
 		{Id: "2", Title: "Hello 2", Desc: "Article Description", Content: "Article Content"},
 	}
+	startWebhookWorkers()
 	handleRequests()
 }
@@ -0,0 +1,911 @@
+package main
+
+import (
+	"bufio"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strconv"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/gorilla/mux"
+)
+
+// handlerEnv lists every variable newHandler reads, so a developer's shell
+// cannot change what a test sees.
+var handlerEnv = []string{
+	"MULTI_TENANT", "TENANTS", "ALLOWED_ORIGINS", "ALLOWED_METHODS", "CORS_ALLOW_CREDENTIALS",
+	"BASIC_AUTH_USER", "BASIC_AUTH_PASS", "JWT_SECRET", "API_KEY",
+	"RATE_LIMIT_RPS", "GLOBAL_RATE_LIMIT_RPS", "TRUST_PROXY", "COMPRESS_MIN_SIZE",
+	"FEATURE_EXPORT", "FEATURE_SEARCH", "FEATURE_BULK", "FEATURE_SUGGEST", "FEATURE_EXISTS",
+	"FEATURE_CONTENT", "FEATURE_NEIGHBORS", "FEATURE_MARKDOWN_EXPORT",
+}
+
+// testHandler builds the full handler chain with env given as key, value pairs.
+func testHandler(t *testing.T, env ...string) http.Handler {
+	t.Helper()
+	for _, key := range handlerEnv {
+		t.Setenv(key, "")
+	}
+	for i := 0; i+1 < len(env); i += 2 {
+		t.Setenv(env[i], env[i+1])
+	}
+	return newHandler()
+}
+
+// withArticles replaces the store for the duration of the test.
+func withArticles(t *testing.T, articles ...Article) {
+	t.Helper()
+	saved := Articles
+	Articles = append([]Article{}, articles...)
+	t.Cleanup(func() { Articles = saved })
+}
+
+// setConfig overrides a package-level setting for the duration of the test.
+func setConfig[T any](t *testing.T, setting *T, value T) {
+	t.Helper()
+	saved := *setting
+	*setting = value
+	t.Cleanup(func() { *setting = saved })
+}
+
+// serve sends one request through h; header holds name, value pairs.
+func serve(h http.Handler, method, target, body string, header ...string) *httptest.ResponseRecorder {
+	req := httptest.NewRequest(method, target, strings.NewReader(body))
+	for i := 0; i+1 < len(header); i += 2 {
+		req.Header.Set(header[i], header[i+1])
+	}
+	rec := httptest.NewRecorder()
+	h.ServeHTTP(rec, req)
+	return rec
+}
+
+func decode(t *testing.T, rec *httptest.ResponseRecorder, v interface{}) {
+	t.Helper()
+	if err := json.Unmarshal(rec.Body.Bytes(), v); err != nil {
+		t.Fatalf("decoding %q: %v", rec.Body.String(), err)
+	}
+}
+
+func articleIds(articles []Article) string {
+	ids := make([]string, len(articles))
+	for i, article := range articles {
+		ids[i] = article.Id
+	}
+	return strings.Join(ids, ",")
+}
+
+var sampleArticles = []Article{
+	{Id: "1", Title: "Hello", Desc: "Article Description", Content: "Article Content"},
+	{Id: "2", Title: "Hello 2", Desc: "Article Description", Content: "Article Content"},
+	{Id: "3", Title: "Third", Desc: "Article Description", Content: "Article Content"},
+}
+
+func TestTitleLengthLimit(t *testing.T) {
+	h := testHandler(t)
+	withArticles(t)
+	for _, tc := range []struct {
+		title string
+		want  int
+	}{
+		{strings.Repeat("a", maxTitleLength), http.StatusCreated},
+		{strings.Repeat("a", maxTitleLength+1), http.StatusBadRequest},
+		{strings.Repeat("é", maxTitleLength), http.StatusCreated},
+	} {
+		body, _ := json.Marshal(Article{Id: "x", Title: tc.title, Content: "c"})
+		if rec := serve(h, "POST", "/articles", string(body)); rec.Code != tc.want {
+			t.Errorf("title of %d characters: got %d, want %d: %s", len([]rune(tc.title)), rec.Code, tc.want, rec.Body)
+		}
+	}
+}
+
+func TestUpdateRejectsBlankArticle(t *testing.T) {
+	h := testHandler(t)
+	withArticles(t, sampleArticles...)
+	for _, tc := range []struct{ method, body string }{
+		{"PUT", `{}`},
+		{"PUT", `{"title":"  ","content":"c"}`},
+		{"PATCH", `{"content":""}`},
+	} {
+		if rec := serve(h, tc.method, "/articles/1", tc.body); rec.Code != http.StatusBadRequest {
+			t.Errorf("%s %s: got %d, want 400", tc.method, tc.body, rec.Code)
+		}
+	}
+	if Articles[0] != sampleArticles[0] {
+		t.Errorf("article changed to %+v", Articles[0])
+	}
+}
+
+func TestUpdateBodyId(t *testing.T) {
+	h := testHandler(t)
+	for _, tc := range []struct {
+		name, body string
+		want       int
+	}{
+		{"matching", `{"id":"1","title":"New","content":"c"}`, http.StatusOK},
+		{"conflicting", `{"id":"2","title":"New","content":"c"}`, http.StatusBadRequest},
+		{"absent", `{"title":"New","content":"c"}`, http.StatusOK},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			withArticles(t, sampleArticles...)
+			rec := serve(h, "PUT", "/articles/1", tc.body)
+			if rec.Code != tc.want {
+				t.Fatalf("got %d, want %d: %s", rec.Code, tc.want, rec.Body)
+			}
+			if Articles[0].Id != "1" || Articles[1].Id != "2" {
+				t.Errorf("ids changed to %s", articleIds(Articles))
+			}
+		})
+	}
+}
+
+func TestEmptyCollectionStatus(t *testing.T) {
+	h := testHandler(t)
+	withArticles(t)
+
+	rec := serve(h, "GET", "/articles", "")
+	var page struct {
+		Data  []Article `json:"data"`
+		Total int       `json:"total"`
+	}
+	decode(t, rec, &page)
+	if rec.Code != http.StatusOK || page.Data == nil || len(page.Data) != 0 || page.Total != 0 {
+		t.Errorf("default mode: got %d %s, want 200 with an empty data array", rec.Code, rec.Body)
+	}
+
+	setConfig(t, &emptyCollectionStatus, http.StatusNoContent)
+	if rec := serve(h, "GET", "/articles", ""); rec.Code != http.StatusNoContent || rec.Body.Len() != 0 {
+		t.Errorf("204 mode: got %d %q, want 204 with no body", rec.Code, rec.Body)
+	}
+}
+
+func TestResponseEnvelope(t *testing.T) {
+	h := testHandler(t)
+	withArticles(t, sampleArticles...)
+
+	var bare map[string]interface{}
+	decode(t, serve(h, "GET", "/articles/1", ""), &bare)
+	if bare["id"] != "1" || bare["data"] != nil {
+		t.Errorf("default body should be the bare article, got %v", bare)
+	}
+
+	rec := serve(h, "GET", "/articles/1?envelope=true", "", "X-Request-ID", "req-1")
+	var envelope struct {
+		Data      Article `json:"data"`
+		RequestId string  `json:"request_id"`
+		Timestamp string  `json:"timestamp"`
+	}
+	decode(t, rec, &envelope)
+	if envelope.Data.Id != "1" || envelope.RequestId != "req-1" || envelope.Timestamp == "" {
+		t.Errorf("unexpected envelope %s", rec.Body)
+	}
+
+	if rec := serve(h, "GET", "/articles/1?envelope=maybe", ""); rec.Code != http.StatusBadRequest {
+		t.Errorf("invalid envelope value: got %d, want 400", rec.Code)
+	}
+}
+
+func TestArticleContentRange(t *testing.T) {
+	h := testHandler(t)
+	withArticles(t, Article{Id: "1", Title: "Digits", Content: "0123456789"})
+
+	rec := serve(h, "GET", "/articles/1/content", "", "Range", "bytes=0-3")
+	if rec.Code != http.StatusPartialContent || rec.Body.String() != "0123" {
+		t.Errorf("valid range: got %d %q, want 206 \"0123\"", rec.Code, rec.Body)
+	}
+	if got := rec.Header().Get("Content-Range"); got != "bytes 0-3/10" {
+		t.Errorf("Content-Range = %q", got)
+	}
+
+	rec = serve(h, "GET", "/articles/1/content", "", "Range", "bytes=50-60")
+	if rec.Code != http.StatusRequestedRangeNotSatisfiable {
+		t.Errorf("unsatisfiable range: got %d, want 416", rec.Code)
+	}
+	if got := rec.Header().Get("Content-Range"); got != "bytes */10" {
+		t.Errorf("Content-Range = %q", got)
+	}
+
+	rec = serve(h, "GET", "/articles/1/content", "")
+	if rec.Code != http.StatusOK || rec.Body.String() != "0123456789" || rec.Header().Get("Accept-Ranges") != "bytes" {
+		t.Errorf("no range: got %d %q, Accept-Ranges %q", rec.Code, rec.Body, rec.Header().Get("Accept-Ranges"))
+	}
+}
+
+func TestHeadSingleArticle(t *testing.T) {
+	h := testHandler(t)
+	withArticles(t, sampleArticles...)
+
+	get := serve(h, "GET", "/articles/1", "")
+	head := serve(h, "HEAD", "/articles/1", "")
+	if head.Code != http.StatusOK || head.Body.Len() != 0 {
+		t.Errorf("present: got %d with %d body bytes, want 200 and no body", head.Code, head.Body.Len())
+	}
+	if got, want := head.Header().Get("Content-Length"), get.Header().Get("Content-Length"); got == "" || got != want {
+		t.Errorf("HEAD Content-Length = %q, GET has %q", got, want)
+	}
+
+	if head := serve(h, "HEAD", "/articles/missing", ""); head.Code != http.StatusNotFound || head.Body.Len() != 0 {
+		t.Errorf("absent: got %d with %d body bytes, want 404 and no body", head.Code, head.Body.Len())
+	}
+}
+
+func TestValidationErrorShape(t *testing.T) {
+	h := testHandler(t)
+	withArticles(t)
+
+	var object struct {
+		Errors []ValidationError `json:"errors"`
+	}
+	decode(t, serve(h, "POST", "/articles", `{"id":"1"}`), &object)
+	if len(object.Errors) == 0 || object.Errors[0].Key != "title" {
+		t.Errorf("object shape: got %+v", object)
+	}
+
+	setConfig(t, &legacyValidationErrors, true)
+	var legacy []ValidationError
+	decode(t, serve(h, "POST", "/articles", `{"id":"1"}`), &legacy)
+	if len(legacy) == 0 || legacy[0].Key != "title" {
+		t.Errorf("legacy shape: got %+v", legacy)
+	}
+}
+
+func TestExportNDJSON(t *testing.T) {
+	h := testHandler(t)
+	withArticles(t, sampleArticles...)
+
+	rec := serve(h, "GET", "/articles/export?format=ndjson", "")
+	if got := rec.Header().Get("Content-Type"); got != "application/x-ndjson" {
+		t.Errorf("Content-Type = %q", got)
+	}
+	var exported []Article
+	scanner := bufio.NewScanner(rec.Body)
+	for scanner.Scan() {
+		var article Article
+		if err := json.Unmarshal(scanner.Bytes(), &article); err != nil {
+			t.Fatalf("line %q: %v", scanner.Text(), err)
+		}
+		exported = append(exported, article)
+	}
+	if got := articleIds(exported); got != "1,2,3" {
+		t.Errorf("exported %s, want 1,2,3", got)
+	}
+
+	if rec := serve(h, "GET", "/articles/export?format=csv", ""); rec.Code != http.StatusBadRequest {
+		t.Errorf("format=csv: got %d, want 400", rec.Code)
+	}
+}
+
+func TestSuggestArticles(t *testing.T) {
+	h := testHandler(t)
+	articles := []Article{{Id: "the-end", Title: "The End", Content: "c"}}
+	for _, id := range strings.Split("a b c d e f g h i j k", " ") {
+		articles = append(articles, Article{Id: id, Title: "Hello " + id, Content: "c"})
+	}
+	withArticles(t, articles...)
+
+	var suggestions []struct{ Id, Title string }
+	decode(t, serve(h, "GET", "/articles/suggest?q=h", ""), &suggestions)
+	if len(suggestions) != maxSuggestions {
+		t.Fatalf("got %d suggestions, want %d", len(suggestions), maxSuggestions)
+	}
+	if suggestions[0].Id != "k" {
+		t.Errorf("newest prefix match should come first, got %q", suggestions[0].Id)
+	}
+
+	withArticles(t, Article{Id: "the-end", Title: "The End"}, Article{Id: "help", Title: "Help"})
+	decode(t, serve(h, "GET", "/articles/suggest?q=he", ""), &suggestions)
+	if len(suggestions) != 2 || suggestions[0].Id != "help" || suggestions[1].Id != "the-end" {
+		t.Errorf("prefix matches should come before substring matches, got %+v", suggestions)
+	}
+
+	rec := serve(h, "GET", "/articles/suggest?q=", "")
+	if strings.TrimSpace(rec.Body.String()) != "[]" {
+		t.Errorf("empty query: got %s, want []", rec.Body)
+	}
+}
+
+func TestTitleNormalization(t *testing.T) {
+	h := testHandler(t)
+	withArticles(t)
+	setConfig(t, &titleUniqueness, "sensitive")
+
+	rec := serve(h, "POST", "/articles", `{"id":"1","title":"Café ","content":"c"}`)
+	if rec.Code != http.StatusCreated || Articles[0].Title != "Café" {
+		t.Fatalf("got %d, stored title %q", rec.Code, Articles[0].Title)
+	}
+	// "e" followed by U+0301 COMBINING ACUTE ACCENT is the NFD form of "é".
+	if rec := serve(h, "POST", "/articles", `{"id":"2","title":"Cafe\u0301","content":"c"}`); rec.Code != http.StatusConflict {
+		t.Errorf("decomposed variant: got %d, want 409", rec.Code)
+	}
+}
+
+func TestCaseInsensitiveTitleUniqueness(t *testing.T) {
+	h := testHandler(t)
+	for _, tc := range []struct {
+		mode string
+		want int
+	}{
+		{"insensitive", http.StatusConflict},
+		{"sensitive", http.StatusCreated},
+	} {
+		t.Run(tc.mode, func(t *testing.T) {
+			withArticles(t)
+			setConfig(t, &titleUniqueness, tc.mode)
+			if rec := serve(h, "POST", "/articles", `{"id":"1","title":"Go","content":"c"}`); rec.Code != http.StatusCreated {
+				t.Fatalf("first create: got %d", rec.Code)
+			}
+			if rec := serve(h, "POST", "/articles", `{"id":"2","title":"go","content":"c"}`); rec.Code != tc.want {
+				t.Errorf("second create: got %d, want %d", rec.Code, tc.want)
+			}
+		})
+	}
+}
+
+func TestListPreview(t *testing.T) {
+	h := testHandler(t)
+	setConfig(t, &previewLength, 10)
+	withArticles(t,
+		Article{Id: "long", Title: "Long", Content: "hello wonderful world"},
+		Article{Id: "short", Title: "Short", Content: "tiny"},
+	)
+
+	var page struct {
+		Data []ArticlePreview `json:"data"`
+	}
+	decode(t, serve(h, "GET", "/articles?preview=true", ""), &page)
+	if got := page.Data[0]; got.Content != "hello" || !got.Truncated {
+		t.Errorf("long content: got %q truncated=%v, want \"hello\" cut at the word boundary", got.Content, got.Truncated)
+	}
+	if got := page.Data[1]; got.Content != "tiny" || got.Truncated {
+		t.Errorf("short content: got %q truncated=%v", got.Content, got.Truncated)
+	}
+
+	var full Article
+	decode(t, serve(h, "GET", "/articles/long", ""), &full)
+	if full.Content != "hello wonderful world" {
+		t.Errorf("single GET content = %q, want it in full", full.Content)
+	}
+}
+
+func TestInternalErrorHidesDetails(t *testing.T) {
+	h := withRequestId(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		writeInternalError(w, r, errors.New(`pq: relation "articles" does not exist`))
+	}))
+	rec := serve(h, "GET", "/", "", "X-Request-ID", "req-42")
+	if rec.Code != http.StatusInternalServerError {
+		t.Fatalf("got %d, want 500", rec.Code)
+	}
+	if strings.Contains(rec.Body.String(), "pq:") || strings.Contains(rec.Body.String(), "relation") {
+		t.Errorf("body leaks the internal error: %s", rec.Body)
+	}
+	var body InternalError
+	decode(t, rec, &body)
+	if body != (InternalError{Message: "internal server error", Code: "INTERNAL", RequestId: "req-42"}) {
+		t.Errorf("got %+v", body)
+	}
+}
+
+func TestCreateIfNoneMatch(t *testing.T) {
+	h := testHandler(t)
+	withArticles(t, sampleArticles...)
+	if rec := serve(h, "POST", "/articles", `{"id":"9","title":"Hello","content":"c"}`, "If-None-Match", "*"); rec.Code != http.StatusPreconditionFailed {
+		t.Errorf("title exists: got %d, want 412", rec.Code)
+	}
+	if rec := serve(h, "POST", "/articles", `{"id":"9","title":"Brand new","content":"c"}`, "If-None-Match", "*"); rec.Code != http.StatusCreated {
+		t.Errorf("title is new: got %d, want 201", rec.Code)
+	}
+}
+
+func TestExportMarkdown(t *testing.T) {
+	h := testHandler(t)
+	withArticles(t, sampleArticles...)
+
+	rec := serve(h, "GET", "/articles/1/export.md", "")
+	if got, want := rec.Body.String(), "# Hello\n\nArticle Content\n"; got != want {
+		t.Errorf("body = %q, want %q", got, want)
+	}
+	if got := rec.Header().Get("Content-Type"); !strings.HasPrefix(got, "text/markdown") {
+		t.Errorf("Content-Type = %q", got)
+	}
+	if got := rec.Header().Get("Content-Disposition"); got != `attachment; filename="article-1.md"` {
+		t.Errorf("Content-Disposition = %q", got)
+	}
+
+	if rec := serve(h, "GET", "/articles/missing/export.md", ""); rec.Code != http.StatusNotFound {
+		t.Errorf("absent: got %d, want 404", rec.Code)
+	}
+}
+
+func TestInflightCounts(t *testing.T) {
+	router := mux.NewRouter()
+	router.Use(trackInflight)
+	release := make(chan struct{})
+	var started, finished sync.WaitGroup
+	router.HandleFunc("/slow/{id}", func(w http.ResponseWriter, r *http.Request) {
+		started.Done()
+		<-release
+	})
+	router.HandleFunc("/panic", func(w http.ResponseWriter, r *http.Request) { panic("boom") })
+	router.HandleFunc("/debug/inflight", returnInflight)
+
+	snapshot := func() (total int64, byRoute map[string]int64) {
+		var body struct {
+			Total   int64            `json:"total"`
+			ByRoute map[string]int64 `json:"by_route"`
+		}
+		decode(t, serve(router, "GET", "/debug/inflight", ""), &body)
+		// The snapshot request is itself in flight.
+		return body.Total - 1, body.ByRoute
+	}
+	base, _ := snapshot()
+
+	const concurrent = 3
+	started.Add(concurrent)
+	finished.Add(concurrent)
+	for i := 0; i < concurrent; i++ {
+		go func() {
+			defer finished.Done()
+			serve(router, "GET", "/slow/1", "")
+		}()
+	}
+	started.Wait()
+	if total, byRoute := snapshot(); total-base != concurrent || byRoute["GET /slow/{id}"] != concurrent {
+		t.Errorf("while busy: total %d, by_route %v, want %d slow requests", total-base, byRoute, concurrent)
+	}
+	close(release)
+	finished.Wait()
+
+	func() {
+		defer func() { recover() }()
+		serve(router, "GET", "/panic", "")
+	}()
+	if total, byRoute := snapshot(); total != base || byRoute["GET /slow/{id}"] != 0 || byRoute["GET /panic"] != 0 {
+		t.Errorf("after completion: total %d (base %d), by_route %v", total, base, byRoute)
+	}
+}
+
+func TestInflightRequiresAPIKey(t *testing.T) {
+	if rec := serve(testHandler(t), "GET", "/debug/inflight", ""); rec.Code != http.StatusNotFound {
+		t.Errorf("without API_KEY: got %d, want 404", rec.Code)
+	}
+	h := testHandler(t, "API_KEY", "secret")
+	if rec := serve(h, "GET", "/debug/inflight", ""); rec.Code != http.StatusUnauthorized {
+		t.Errorf("missing key: got %d, want 401", rec.Code)
+	}
+	if rec := serve(h, "GET", "/debug/inflight", "", "X-API-Key", "secret"); rec.Code != http.StatusOK {
+		t.Errorf("valid key: got %d, want 200", rec.Code)
+	}
+}
+
+func TestKeyCase(t *testing.T) {
+	h := testHandler(t)
+	withArticles(t)
+	for _, tc := range []struct {
+		target, header, want string
+	}{
+		{"/articles/missing", "", "request_id"},
+		{"/articles/missing?case=camel", "", "requestId"},
+		{"/articles/missing", "camel", "requestId"},
+	} {
+		rec := serve(h, "GET", tc.target, "", "X-Request-ID", "r1", "X-Key-Case", tc.header)
+		var body map[string]interface{}
+		decode(t, rec, &body)
+		if body[tc.want] != "r1" {
+			t.Errorf("%s (X-Key-Case %q): want key %q, got %s", tc.target, tc.header, tc.want, rec.Body)
+		}
+	}
+}
+
+func TestKeyCaseKeepsMapKeys(t *testing.T) {
+	for _, tc := range []struct {
+		name string
+		v    interface{}
+		want string
+	}{
+		{"features", map[string]bool{"bulk_delete": true}, `{"bulk_delete":true}`},
+		{"inflight", struct {
+			ByRoute map[string]int64 `json:"by_route"`
+		}{map[string]int64{"/by_tag": 1}}, `{"byRoute":{"/by_tag":1}}`},
+		{"struct inside a map", map[string]ComponentStatus{"webhook_target": {Status: "up", LatencyMs: 2}},
+			`{"webhook_target":{"critical":false,"latencyMs":2,"status":"up"}}`},
+		{"embedded struct", []struct {
+			CustomError
+			Tags map[string]int `json:"by_tag"`
+		}{{CustomError{"m", "r1"}, map[string]int{"dry_run": 1}}}, `[{"byTag":{"dry_run":1},"message":"m","requestId":"r1"}]`},
+	} {
+		rec := httptest.NewRecorder()
+		writeJSON(rec, httptest.NewRequest("GET", "/?case=camel", nil), http.StatusOK, tc.v)
+		if got := strings.TrimSpace(rec.Body.String()); got != tc.want {
+			t.Errorf("%s: got %s, want %s", tc.name, got, tc.want)
+		}
+	}
+
+	rec := serve(testHandler(t), "GET", "/articles/missing?envelope=true&case=camel", "", "X-Request-ID", "r1")
+	var body map[string]interface{}
+	decode(t, rec, &body)
+	if body["requestId"] != "r1" {
+		t.Errorf("envelope keys were not converted: %s", rec.Body)
+	}
+}
+
+func TestParseBool(t *testing.T) {
+	for value, want := range map[string]bool{
+		"true": true, "TRUE": true, "1": true, "yes": true, "Yes": true,
+		"false": false, "0": false, "no": false, "NO": false,
+	} {
+		if got, err := parseBool(value); err != nil || got != want {
+			t.Errorf("parseBool(%q) = %v, %v; want %v", value, got, err, want)
+		}
+	}
+	for _, value := range []string{"ture", "y", "2", "on"} {
+		if _, err := parseBool(value); err == nil {
+			t.Errorf("parseBool(%q) should fail", value)
+		}
+	}
+
+	h := testHandler(t)
+	withArticles(t, sampleArticles...)
+	if rec := serve(h, "GET", "/articles?preview=ture", ""); rec.Code != http.StatusBadRequest {
+		t.Errorf("?preview=ture: got %d, want 400", rec.Code)
+	}
+	if rec := serve(h, "GET", "/articles?preview=YES", ""); rec.Code != http.StatusOK {
+		t.Errorf("?preview=YES: got %d, want 200", rec.Code)
+	}
+}
+
+func TestReorderArticles(t *testing.T) {
+	h := testHandler(t)
+	withArticles(t, sampleArticles...)
+
+	var ordered []Article
+	rec := serve(h, "PUT", "/articles/order", `["3","1"]`)
+	decode(t, rec, &ordered)
+	if got := articleIds(ordered); got != "3,1,2" {
+		t.Errorf("response order %s, want 3,1,2 with the unplaced article last", got)
+	}
+
+	var page struct {
+		Data []Article `json:"data"`
+	}
+	decode(t, serve(h, "GET", "/articles?sort=position", ""), &page)
+	if got := articleIds(page.Data); got != "3,1,2" {
+		t.Errorf("?sort=position order %s, want 3,1,2", got)
+	}
+
+	for _, body := range []string{`["1","1"]`, `["404"]`, `{"ids":["1"]}`} {
+		if rec := serve(h, "PUT", "/articles/order", body); rec.Code != http.StatusBadRequest {
+			t.Errorf("%s: got %d, want 400", body, rec.Code)
+		}
+	}
+
+	withArticles(t, Article{Id: "1", Title: "One"}, Article{Id: "1", Title: "Also one"})
+	if rec := serve(h, "PUT", "/articles/order", `["1","404"]`); rec.Code != http.StatusBadRequest {
+		t.Errorf("an unknown id hidden by a repeated stored id: got %d, want 400", rec.Code)
+	}
+}
+
+func TestSortOrder(t *testing.T) {
+	h := testHandler(t)
+	withArticles(t, sampleArticles...)
+	serve(h, "PUT", "/articles/order", `["2","3","1"]`)
+
+	for _, tc := range []struct {
+		query, want string
+	}{
+		{"sort=position", "2,3,1"},
+		{"sort=position&order=asc", "2,3,1"},
+		{"sort=-position", "1,3,2"},
+		{"sort=position&order=desc", "1,3,2"},
+		{"sort=-position&order=DESC", "1,3,2"},
+	} {
+		var page struct {
+			Data []Article `json:"data"`
+		}
+		rec := serve(h, "GET", "/articles?"+tc.query, "")
+		decode(t, rec, &page)
+		if got := articleIds(page.Data); got != tc.want {
+			t.Errorf("?%s: got %s, want %s", tc.query, got, tc.want)
+		}
+	}
+	for _, query := range []string{"sort=position&order=sideways", "sort=-position&order=asc", "order=desc", "sort=title"} {
+		if rec := serve(h, "GET", "/articles?"+query, ""); rec.Code != http.StatusBadRequest {
+			t.Errorf("?%s: got %d, want 400", query, rec.Code)
+		}
+	}
+}
+
+func TestArticleNeighbors(t *testing.T) {
+	h := testHandler(t)
+	withArticles(t, sampleArticles...)
+	for _, tc := range []struct {
+		id, previous, next string
+	}{
+		{"1", "", "2"},
+		{"2", "1", "3"},
+		{"3", "2", ""},
+	} {
+		var neighbors struct {
+			Previous *Article `json:"previous"`
+			Next     *Article `json:"next"`
+		}
+		decode(t, serve(h, "GET", "/articles/"+tc.id+"/neighbors", ""), &neighbors)
+		got := func(a *Article) string {
+			if a == nil {
+				return ""
+			}
+			return a.Id
+		}
+		if got(neighbors.Previous) != tc.previous || got(neighbors.Next) != tc.next {
+			t.Errorf("article %s: previous %q next %q, want %q and %q",
+				tc.id, got(neighbors.Previous), got(neighbors.Next), tc.previous, tc.next)
+		}
+	}
+	if rec := serve(h, "GET", "/articles/missing/neighbors", ""); rec.Code != http.StatusNotFound {
+		t.Errorf("absent: got %d, want 404", rec.Code)
+	}
+
+	// Without ?sort= neighbors follow id order, not the order articles were
+	// stored in, and numeric ids compare as numbers.
+	withArticles(t, Article{Id: "10", Title: "Ten"}, Article{Id: "2", Title: "Two"}, Article{Id: "9", Title: "Nine"})
+	var neighbors struct {
+		Previous *Article `json:"previous"`
+		Next     *Article `json:"next"`
+	}
+	decode(t, serve(h, "GET", "/articles/9/neighbors", ""), &neighbors)
+	if neighbors.Previous == nil || neighbors.Previous.Id != "2" || neighbors.Next == nil || neighbors.Next.Id != "10" {
+		t.Errorf("article 9: got %+v, want 2 before and 10 after", neighbors)
+	}
+}
+
+func TestFeatureFlags(t *testing.T) {
+	withArticles(t, sampleArticles...)
+	h := testHandler(t, "FEATURE_NEIGHBORS", "false")
+
+	var listed map[string]bool
+	decode(t, serve(h, "GET", "/features", ""), &listed)
+	for _, name := range []string{"suggest", "exists", "markdown_export", "content"} {
+		if !listed[name] {
+			t.Errorf("/features does not list %s as enabled: %v", name, listed)
+		}
+	}
+	if enabled, ok := listed["neighbors"]; !ok || enabled {
+		t.Errorf("/features should list neighbors as disabled: %v", listed)
+	}
+	if rec := serve(h, "GET", "/articles/1/neighbors", ""); rec.Code != http.StatusNotFound {
+		t.Errorf("disabled neighbors route: got %d, want 404", rec.Code)
+	}
+}
+
+func TestCreateArticlesBatchIds(t *testing.T) {
+	h := testHandler(t)
+	withArticles(t, sampleArticles...)
+
+	for _, tc := range []struct {
+		name, body string
+		index      int
+	}{
+		{"missing", `[{"id":"10","title":"A","content":"c"},{"title":"B","content":"c"}]`, 1},
+		{"repeated", `[{"id":"10","title":"A","content":"c"},{"id":"10","title":"B","content":"c"}]`, 1},
+		{"stored", `[{"id":"1","title":"A","content":"c"}]`, 0},
+	} {
+		var body struct {
+			Errors []BatchError `json:"errors"`
+		}
+		rec := serve(h, "POST", "/articles/batch", tc.body)
+		decode(t, rec, &body)
+		if rec.Code != http.StatusBadRequest || len(body.Errors) != 1 || body.Errors[0].Index != tc.index || body.Errors[0].Errors[0].Key != "id" {
+			t.Errorf("%s id: got %d %s, want 400 for index %d", tc.name, rec.Code, rec.Body, tc.index)
+		}
+	}
+	if len(Articles) != len(sampleArticles) {
+		t.Errorf("a rejected batch stored articles: %s", articleIds(Articles))
+	}
+}
+
+// countingReader counts the bytes read through it.
+type countingReader struct {
+	r io.Reader
+	n int
+}
+
+func (c *countingReader) Read(p []byte) (int, error) {
+	n, err := c.r.Read(p)
+	c.n += n
+	return n, err
+}
+
+func TestCreateArticlesBatchStreaming(t *testing.T) {
+	h := testHandler(t)
+	withArticles(t)
+
+	var oversized strings.Builder
+	oversized.WriteString("[")
+	for i := 0; i < 2*maxBatchSize; i++ {
+		if i > 0 {
+			oversized.WriteString(",")
+		}
+		fmt.Fprintf(&oversized, `{"id":"%d","title":"Article %d","content":"c"}`, i, i)
+	}
+	oversized.WriteString("]")
+	for _, tc := range []struct {
+		decoding string
+		readAll  bool
+	}{
+		{"stream", false},
+		{"buffered", true},
+	} {
+		setConfig(t, &batchDecoding, tc.decoding)
+		body := &countingReader{r: strings.NewReader(oversized.String())}
+		rec := httptest.NewRecorder()
+		h.ServeHTTP(rec, httptest.NewRequest("POST", "/articles/batch", body))
+		if rec.Code != http.StatusBadRequest || len(Articles) != 0 {
+			t.Errorf("%s: got %d with %d stored, want 400 and nothing stored", tc.decoding, rec.Code, len(Articles))
+		}
+		if readAll := body.n == oversized.Len(); readAll != tc.readAll {
+			t.Errorf("%s: read %d of %d bytes", tc.decoding, body.n, oversized.Len())
+		}
+	}
+
+	setConfig(t, &batchDecoding, "stream")
+	rec := serve(h, "POST", "/articles/batch", `[{"id":"1","title":"One","content":"c"},{"id":"2","title":"Two","content":"c"}]`)
+	if rec.Code != http.StatusCreated || len(Articles) != 2 {
+		t.Errorf("valid batch: got %d with %d stored", rec.Code, len(Articles))
+	}
+	for _, body := range []string{`{"id":"3"}`, `[{"id":"3","title":"Three","content":"c"}] []`, `[{"id":"3"`, ``} {
+		if rec := serve(h, "POST", "/articles/batch", body); rec.Code != http.StatusBadRequest {
+			t.Errorf("%q: got %d, want 400", body, rec.Code)
+		}
+	}
+}
+
+func TestPatchReturnChanged(t *testing.T) {
+	h := testHandler(t)
+	withArticles(t, sampleArticles...)
+
+	var changed map[string]interface{}
+	rec := serve(h, "PATCH", "/articles/1?return=changed", `{"title":"Hello","content":"New content"}`)
+	decode(t, rec, &changed)
+	if len(changed) != 1 || changed["content"] != "New content" {
+		t.Errorf("got %d %s, want only the changed content", rec.Code, rec.Body)
+	}
+
+	var article Article
+	decode(t, serve(h, "PATCH", "/articles/1", `{"desc":"New desc"}`), &article)
+	if article.Id != "1" || article.Title != "Hello" || article.Content != "New content" {
+		t.Errorf("default response should be the full article, got %+v", article)
+	}
+	if rec := serve(h, "PATCH", "/articles/1?return=everything", `{"desc":"d"}`); rec.Code != http.StatusBadRequest {
+		t.Errorf("unknown return value: got %d, want 400", rec.Code)
+	}
+}
+
+func TestOverallHealth(t *testing.T) {
+	up := ComponentStatus{Status: "up"}
+	down := ComponentStatus{Status: "down"}
+	criticalUp := ComponentStatus{Status: "up", Critical: true}
+	criticalDown := ComponentStatus{Status: "down", Critical: true}
+	for _, tc := range []struct {
+		name       string
+		components map[string]ComponentStatus
+		overall    string
+		status     int
+	}{
+		{"no components", nil, "ok", http.StatusOK},
+		{"all up", map[string]ComponentStatus{"db": criticalUp, "webhook": up}, "ok", http.StatusOK},
+		{"non-critical down", map[string]ComponentStatus{"db": criticalUp, "webhook": down}, "degraded", http.StatusOK},
+		{"critical down", map[string]ComponentStatus{"db": criticalDown, "webhook": up}, "down", http.StatusServiceUnavailable},
+		{"everything down", map[string]ComponentStatus{"db": criticalDown, "webhook": down}, "down", http.StatusServiceUnavailable},
+	} {
+		if overall, status := overallHealth(tc.components); overall != tc.overall || status != tc.status {
+			t.Errorf("%s: got %s %d, want %s %d", tc.name, overall, status, tc.overall, tc.status)
+		}
+	}
+}
+
+func TestReady(t *testing.T) {
+	h := testHandler(t)
+	if rec := serve(h, "GET", "/ready", ""); rec.Code != http.StatusOK {
+		t.Errorf("before shutdown: got %d, want 200", rec.Code)
+	}
+	shuttingDown.Store(true)
+	t.Cleanup(func() { shuttingDown.Store(false) })
+	rec := serve(h, "GET", "/ready", "")
+	var body struct {
+		Status string `json:"status"`
+	}
+	decode(t, rec, &body)
+	if rec.Code != http.StatusServiceUnavailable || body.Status != "unavailable" {
+		t.Errorf("during shutdown: got %d %q, want 503 unavailable", rec.Code, body.Status)
+	}
+	if rec := serve(h, "GET", "/health", ""); rec.Code != http.StatusOK {
+		t.Errorf("liveness must stay up during shutdown, got %d", rec.Code)
+	}
+}
+
+func TestDetailedHealthWebhook(t *testing.T) {
+	h := testHandler(t)
+	target := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {}))
+	defer target.Close()
+	closed := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {}))
+	closed.Close()
+
+	for _, tc := range []struct {
+		name, url, overall string
+	}{
+		{"no webhook", "", "ok"},
+		{"webhook up", target.URL, "ok"},
+		{"webhook down", closed.URL, "degraded"},
+	} {
+		setConfig(t, &webhookURL, tc.url)
+		rec := serve(h, "GET", "/health/detailed", "")
+		var body struct {
+			Status string `json:"status"`
+		}
+		decode(t, rec, &body)
+		if rec.Code != http.StatusOK || body.Status != tc.overall {
+			t.Errorf("%s: got %d %s, want 200 %s", tc.name, rec.Code, body.Status, tc.overall)
+		}
+	}
+}
+
+func TestBackgroundShutdown(t *testing.T) {
+	// Workers that stop when asked to drain let shutdown return right away.
+	workers := newBackgroundGroup()
+	workers.run(newRateLimiter(1, 1, 0, 0, false).sweepIdle)
+	start := time.Now()
+	workers.shutdown(time.Minute)
+	if elapsed := time.Since(start); elapsed > time.Second {
+		t.Errorf("shutdown waited %v for a worker that had nothing to drain", elapsed)
+	}
+	if workers.ctx.Err() == nil {
+		t.Error("context not cancelled after shutdown")
+	}
+
+	// A worker that ignores draining is cancelled once the deadline passes,
+	// and shutdown still waits for it to exit.
+	workers = newBackgroundGroup()
+	var exited bool
+	workers.run(func(ctx context.Context, draining <-chan struct{}) {
+		<-ctx.Done()
+		exited = true
+	})
+	workers.shutdown(10 * time.Millisecond)
+	if !exited {
+		t.Error("shutdown returned before the cancelled worker exited")
+	}
+}
+
+func TestConcurrentWrites(t *testing.T) {
+	h := testHandler(t)
+	withArticles(t, sampleArticles...)
+	const writers = 20
+	var wg sync.WaitGroup
+	for i := 0; i < writers; i++ {
+		id := strconv.Itoa(100 + i)
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			serve(h, "POST", "/articles", `{"id":"`+id+`","title":"T`+id+`","content":"c"}`)
+			serve(h, "PATCH", "/articles/"+id, `{"desc":"d"}`)
+			serve(h, "GET", "/articles/"+id, "")
+			serve(h, "GET", "/articles", "")
+			serve(h, "PUT", "/articles/order", `["`+id+`"]`)
+			serve(h, "POST", "/articles/batch", `[{"id":"b`+id+`","title":"B`+id+`","content":"c"}]`)
+			serve(h, "DELETE", "/articles", `{"ids":["`+id+`","b`+id+`"]}`)
+			serve(h, "DELETE", "/articles/1", "")
+		}()
+	}
+	wg.Wait()
+	// Each writer removes its own article and article 1 goes once, so only 2
+	// and 3 are left whatever the interleaving.
+	if ids := articleIds(Articles); ids != "2,3" {
+		t.Errorf("articles left: %v, want 2 and 3", ids)
+	}
+}
@@ -0,0 +1,57 @@
+package main
+
+import (
+	"net/http"
+	"strconv"
+	"time"
+
+	"github.com/gorilla/mux"
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/promauto"
+)
+
+var (
+	httpRequestsTotal = promauto.NewCounterVec(prometheus.CounterOpts{
+		Name: "http_requests_total",
+		Help: "HTTP requests served, by method, route and status code.",
+	}, []string{"method", "path", "status"})
+	httpRequestDuration = promauto.NewHistogramVec(prometheus.HistogramOpts{
+		Name:    "http_request_duration_seconds",
+		Help:    "Time taken to serve HTTP requests, by method and route.",
+		Buckets: prometheus.DefBuckets,
+	}, []string{"method", "path"})
+	_ = promauto.NewCounterFunc(prometheus.CounterOpts{
+		Name: "webhook_dead_letters_total",
+		Help: "Webhook events dropped after every delivery attempt failed.",
+	}, func() float64 { return float64(webhookDeadLetters.Load()) })
+)
+
+// recordMetrics counts and times every request except scrapes of /metrics.
+// The path label is the matched route template ("/articles/{id}"), never the
+// raw path, so ids cannot blow up label cardinality; requests no route
+// matches are recorded as "unmatched".
+func recordMetrics(router *mux.Router) func(http.Handler) http.Handler {
+	return func(next http.Handler) http.Handler {
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			if r.URL.Path == "/metrics" {
+				next.ServeHTTP(w, r)
+				return
+			}
+			path := "unmatched"
+			var match mux.RouteMatch
+			if router.Match(r, &match) && match.Route != nil {
+				if template, err := match.Route.GetPathTemplate(); err == nil {
+					path = template
+				}
+			}
+			start := time.Now()
+			sr := &statusRecorder{ResponseWriter: w}
+			next.ServeHTTP(sr, r)
+			if sr.status == 0 {
+				sr.status = http.StatusOK
+			}
+			httpRequestsTotal.WithLabelValues(r.Method, path, strconv.Itoa(sr.status)).Inc()
+			httpRequestDuration.WithLabelValues(r.Method, path).Observe(time.Since(start).Seconds())
+		})
+	}
+}
@@ -0,0 +1,385 @@
+package main
+
+import (
+	"compress/gzip"
+	"context"
+	"crypto/sha256"
+	"crypto/subtle"
+	"io"
+	"log"
+	"net/http"
+	"net/url"
+	"strconv"
+	"strings"
+
+	"github.com/andybalholm/brotli"
+	"github.com/golang-jwt/jwt/v5"
+	"golang.org/x/crypto/bcrypt"
+)
+
+// originAllowed reports whether origin matches one of the allowed patterns.
+// Patterns are either exact origins ("https://app.example.com") or subdomain
+// wildcards ("*.example.com" or "https://*.example.com:3000"). A wildcard
+// without a port matches the subdomain on any port.
+func originAllowed(origin string, allowedOrigins []string) bool {
+	u, err := url.Parse(origin)
+	if err != nil || u.Scheme == "" || u.Host == "" {
+		return false
+	}
+	for _, pattern := range allowedOrigins {
+		if pattern == origin {
+			return true
+		}
+		scheme, host := "", pattern
+		if i := strings.Index(pattern, "://"); i >= 0 {
+			scheme, host = pattern[:i], pattern[i+3:]
+		}
+		if !strings.HasPrefix(host, "*.") || (scheme != "" && scheme != u.Scheme) {
+			continue
+		}
+		domain, port, hasPort := strings.Cut(host[1:], ":")
+		if hasPort && port != u.Port() {
+			continue
+		}
+		if strings.HasSuffix(u.Hostname(), domain) {
+			return true
+		}
+	}
+	return false
+}
+
+// corsAllowHeaders are the request headers browsers may send cross-origin.
+const corsAllowHeaders = "Accept, Authorization, Content-Type, If-None-Match, X-API-Key, X-Key-Case, X-Request-ID, X-Tenant-ID"
+
+// corsExposeHeaders are the non-safelisted response headers scripts may read.
+const corsExposeHeaders = "Content-Range, Retry-After, X-RateLimit-Scope, X-Request-ID"
+
+// cors adds CORS headers for origins on the allowlist; other origins get none
+// at all. A "*" entry allows every origin and is answered with a literal "*".
+// allowCredentials only applies to origins matched by an explicit entry, so a
+// wildcard never grants credentialed access.
+// Preflight requests are answered here with 204 and never reach the router.
+// methods is what preflights advertise; empty means the router's full set.
+func cors(allowedOrigins []string, methods []string, allowCredentials bool) func(http.Handler) http.Handler {
+	allowAny := false
+	for _, pattern := range allowedOrigins {
+		if pattern == "*" {
+			allowAny = true
+		}
+	}
+	allowMethodsHeader := "GET, HEAD, POST, PUT, PATCH, DELETE"
+	if len(methods) > 0 {
+		allowMethodsHeader = strings.ToUpper(strings.Join(methods, ", "))
+	}
+	return func(next http.Handler) http.Handler {
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			origin := r.Header.Get("Origin")
+			if origin != "" {
+				w.Header().Add("Vary", "Origin")
+				switch {
+				case allowAny:
+					w.Header().Set("Access-Control-Allow-Origin", "*")
+				case originAllowed(origin, allowedOrigins):
+					w.Header().Set("Access-Control-Allow-Origin", origin)
+					if allowCredentials {
+						w.Header().Set("Access-Control-Allow-Credentials", "true")
+					}
+				}
+			}
+			if r.Method == http.MethodOptions && origin != "" && r.Header.Get("Access-Control-Request-Method") != "" {
+				if w.Header().Get("Access-Control-Allow-Origin") != "" {
+					w.Header().Set("Access-Control-Allow-Methods", allowMethodsHeader)
+					w.Header().Set("Access-Control-Allow-Headers", corsAllowHeaders)
+					w.Header().Set("Access-Control-Max-Age", "600")
+				}
+				w.WriteHeader(http.StatusNoContent)
+				return
+			}
+			if w.Header().Get("Access-Control-Allow-Origin") != "" {
+				w.Header().Set("Access-Control-Expose-Headers", corsExposeHeaders)
+			}
+			next.ServeHTTP(w, r)
+		})
+	}
+}
+
+// negotiateEncoding picks the response encoding from Accept-Encoding using
+// the client's quality values. Brotli wins ties with gzip; "identity" is
+// returned when neither is acceptable.
+func negotiateEncoding(acceptEncoding string) string {
+	quality := map[string]float64{}
+	for _, part := range strings.Split(acceptEncoding, ",") {
+		name, params, _ := strings.Cut(strings.TrimSpace(part), ";")
+		name = strings.ToLower(strings.TrimSpace(name))
+		if name == "" {
+			continue
+		}
+		q := 1.0
+		if key, value, ok := strings.Cut(strings.TrimSpace(params), "="); ok && strings.TrimSpace(key) == "q" {
+			if parsed, err := strconv.ParseFloat(strings.TrimSpace(value), 64); err == nil {
+				q = parsed
+			}
+		}
+		quality[name] = q
+	}
+	best, bestQ := "identity", 0.0
+	for _, encoding := range []string{"br", "gzip"} {
+		q, ok := quality[encoding]
+		if !ok {
+			q, ok = quality["*"]
+		}
+		if ok && q > bestQ {
+			best, bestQ = encoding, q
+		}
+	}
+	return best
+}
+
+// compressResponseWriter holds back the status line and the first minSize
+// bytes of the body so that small responses can still go out uncompressed
+// with an exact Content-Length. A Content-Length set by the handler decides
+// straight away.
+type compressResponseWriter struct {
+	http.ResponseWriter
+	encoding    string
+	minSize     int
+	encoder     io.WriteCloser
+	status      int
+	buf         []byte
+	wroteHeader bool // the status has been forwarded to ResponseWriter
+}
+
+func (cw *compressResponseWriter) WriteHeader(status int) {
+	if cw.status != 0 {
+		return
+	}
+	cw.status = status
+	// Byte ranges refer to the identity representation, so partial and
+	// unsatisfiable-range responses are sent uncompressed.
+	compressible := status != http.StatusNoContent && status != http.StatusNotModified &&
+		cw.Header().Get("Content-Encoding") == "" && cw.Header().Get("Content-Range") == ""
+	if !compressible {
+		cw.start(false)
+		return
+	}
+	if length := cw.Header().Get("Content-Length"); length != "" {
+		n, err := strconv.Atoi(length)
+		cw.start(err == nil && n >= cw.minSize)
+	}
+}
+
+// start forwards the held-back status, with or without compression, and
+// then any buffered body.
+func (cw *compressResponseWriter) start(compressed bool) {
+	cw.wroteHeader = true
+	if compressed {
+		cw.Header().Set("Content-Encoding", cw.encoding)
+		cw.Header().Del("Content-Length")
+		if cw.encoding == "br" {
+			cw.encoder = brotli.NewWriter(cw.ResponseWriter)
+		} else {
+			cw.encoder = gzip.NewWriter(cw.ResponseWriter)
+		}
+	}
+	cw.ResponseWriter.WriteHeader(cw.status)
+	if len(cw.buf) > 0 {
+		buf := cw.buf
+		cw.buf = nil
+		cw.write(buf)
+	}
+}
+
+func (cw *compressResponseWriter) write(b []byte) (int, error) {
+	if cw.encoder == nil {
+		return cw.ResponseWriter.Write(b)
+	}
+	return cw.encoder.Write(b)
+}
+
+func (cw *compressResponseWriter) Write(b []byte) (int, error) {
+	if cw.status == 0 {
+		if cw.Header().Get("Content-Type") == "" {
+			cw.Header().Set("Content-Type", http.DetectContentType(b))
+		}
+		cw.WriteHeader(http.StatusOK)
+	}
+	if cw.wroteHeader {
+		return cw.write(b)
+	}
+	cw.buf = append(cw.buf, b...)
+	if len(cw.buf) >= cw.minSize {
+		cw.start(true)
+	}
+	return len(b), nil
+}
+
+// Flush means the handler is streaming, so the response is compressed even
+// if fewer than minSize bytes have been written so far.
+func (cw *compressResponseWriter) Flush() {
+	if !cw.wroteHeader {
+		if cw.status == 0 {
+			cw.WriteHeader(http.StatusOK)
+		}
+		if !cw.wroteHeader {
+			cw.start(true)
+		}
+	}
+	if flusher, ok := cw.encoder.(interface{ Flush() error }); ok {
+		flusher.Flush()
+	}
+	if flusher, ok := cw.ResponseWriter.(http.Flusher); ok {
+		flusher.Flush()
+	}
+}
+
+// Close sends a response that stayed below minSize uncompressed. Only here is
+// the whole body known, so this is the one place a Content-Length is added.
+func (cw *compressResponseWriter) Close() error {
+	if cw.status != 0 && !cw.wroteHeader {
+		if cw.Header().Get("Content-Length") == "" && cw.status != http.StatusNoContent && cw.status != http.StatusNotModified {
+			cw.Header().Set("Content-Length", strconv.Itoa(len(cw.buf)))
+		}
+		cw.start(false)
+	}
+	if cw.encoder == nil {
+		return nil
+	}
+	return cw.encoder.Close()
+}
+
+// compress encodes responses of at least minSize bytes with brotli or gzip,
+// whichever the client prefers; smaller ones are not worth the overhead.
+func compress(minSize int) func(http.Handler) http.Handler {
+	return func(next http.Handler) http.Handler {
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			w.Header().Add("Vary", "Accept-Encoding")
+			encoding := negotiateEncoding(r.Header.Get("Accept-Encoding"))
+			if encoding == "identity" {
+				next.ServeHTTP(w, r)
+				return
+			}
+			cw := &compressResponseWriter{ResponseWriter: w, encoding: encoding, minSize: minSize}
+			defer cw.Close()
+			next.ServeHTTP(cw, r)
+		})
+	}
+}
+
+// basicAuth requires HTTP Basic credentials matching user and the bcrypt
+// passwordHash. It lets every request through when user is empty.
+func basicAuth(user, passwordHash string) func(http.Handler) http.Handler {
+	if user == "" {
+		return func(next http.Handler) http.Handler { return next }
+	}
+	if _, err := bcrypt.Cost([]byte(passwordHash)); err != nil {
+		log.Fatalf("invalid BASIC_AUTH_PASS: must be a bcrypt hash: %v", err)
+	}
+	return func(next http.Handler) http.Handler {
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			givenUser, givenPass, ok := r.BasicAuth()
+			userMatch := subtle.ConstantTimeCompare([]byte(givenUser), []byte(user)) == 1
+			passMatch := bcrypt.CompareHashAndPassword([]byte(passwordHash), []byte(givenPass)) == nil
+			if !ok || !userMatch || !passMatch {
+				w.Header().Set("WWW-Authenticate", `Basic realm="articles", charset="UTF-8"`)
+				writeError(w, r, http.StatusUnauthorized, "Unauthorized")
+				return
+			}
+			next.ServeHTTP(w, r)
+		})
+	}
+}
+
+// allowMethods rejects any request whose method is not in methods with 405,
+// before it reaches the router. An empty list allows every method.
+func allowMethods(methods []string) func(http.Handler) http.Handler {
+	if len(methods) == 0 {
+		return func(next http.Handler) http.Handler { return next }
+	}
+	// The caller's slice is shared with cors, so it is not upper-cased in place.
+	upper := make([]string, len(methods))
+	allowed := make(map[string]bool, len(methods))
+	for i, method := range methods {
+		upper[i] = strings.ToUpper(method)
+		allowed[upper[i]] = true
+	}
+	allowHeader := strings.Join(upper, ", ")
+	return func(next http.Handler) http.Handler {
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			if !allowed[r.Method] {
+				w.Header().Set("Allow", allowHeader)
+				writeError(w, r, http.StatusMethodNotAllowed, "Method not allowed")
+				return
+			}
+			next.ServeHTTP(w, r)
+		})
+	}
+}
+
+// checkEnvelopeParam rejects a malformed ?envelope= up front, since writeJSON
+// applies it to every response and cannot report the error itself.
+func checkEnvelopeParam(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if _, err := queryBool(r, "envelope"); err != nil {
+			writeError(w, r, http.StatusBadRequest, err.Error())
+			return
+		}
+		next.ServeHTTP(w, r)
+	})
+}
+
+// apiKeyAuth requires an X-API-Key header equal to apiKey. Both values are
+// hashed before the constant-time comparison so the key length does not leak
+// either. It lets every request through when apiKey is empty.
+func apiKeyAuth(apiKey string) func(http.Handler) http.Handler {
+	if apiKey == "" {
+		return func(next http.Handler) http.Handler { return next }
+	}
+	want := sha256.Sum256([]byte(apiKey))
+	return func(next http.Handler) http.Handler {
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			got := sha256.Sum256([]byte(r.Header.Get("X-API-Key")))
+			if subtle.ConstantTimeCompare(got[:], want[:]) != 1 {
+				writeError(w, r, http.StatusUnauthorized, "Missing or invalid API key")
+				return
+			}
+			next.ServeHTTP(w, r)
+		})
+	}
+}
+
+const claimsContextKey contextKey = "claims"
+
+// jwtAuth requires an HS256/384/512 bearer token signed with secret and puts
+// its claims in the request context (see requestClaims). It lets every
+// request through when secret is empty.
+func jwtAuth(secret string) func(http.Handler) http.Handler {
+	if secret == "" {
+		return func(next http.Handler) http.Handler { return next }
+	}
+	parser := jwt.NewParser(jwt.WithValidMethods([]string{"HS256", "HS384", "HS512"}))
+	keyFunc := func(*jwt.Token) (interface{}, error) { return []byte(secret), nil }
+	return func(next http.Handler) http.Handler {
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			tokenString, ok := strings.CutPrefix(r.Header.Get("Authorization"), "Bearer ")
+			claims := jwt.MapClaims{}
+			if !ok || tokenString == "" {
+				w.Header().Set("WWW-Authenticate", `Bearer realm="articles"`)
+				writeError(w, r, http.StatusUnauthorized, "Missing bearer token")
+				return
+			}
+			if _, err := parser.ParseWithClaims(tokenString, claims, keyFunc); err != nil {
+				w.Header().Set("WWW-Authenticate", `Bearer realm="articles", error="invalid_token"`)
+				writeError(w, r, http.StatusUnauthorized, "Invalid bearer token")
+				return
+			}
+			next.ServeHTTP(w, r.WithContext(context.WithValue(r.Context(), claimsContextKey, claims)))
+		})
+	}
+}
+
+// requestClaims returns the JWT claims of an authenticated request, or nil
+// when jwtAuth did not run.
+func requestClaims(r *http.Request) jwt.MapClaims {
+	claims, _ := r.Context().Value(claimsContextKey).(jwt.MapClaims)
+	return claims
+}
@@ -0,0 +1,389 @@
+package main
+
+import (
+	"compress/gzip"
+	"crypto/rand"
+	"crypto/rsa"
+	"encoding/json"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"os/exec"
+	"strconv"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/andybalholm/brotli"
+	"github.com/golang-jwt/jwt/v5"
+	"golang.org/x/crypto/bcrypt"
+	"golang.org/x/time/rate"
+)
+
+var okHandler = http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+	w.Write([]byte("ok"))
+})
+
+func TestCorsAllowlist(t *testing.T) {
+	h := cors([]string{"https://app.test", "*.example.com", "https://*.example.org:3000"}, nil, false)(okHandler)
+	for _, tc := range []struct {
+		name, origin, want string
+	}{
+		{"exact", "https://app.test", "https://app.test"},
+		{"wildcard", "https://api.example.com", "https://api.example.com"},
+		{"nested wildcard", "http://a.b.example.com", "http://a.b.example.com"},
+		{"disallowed", "https://evil.test", ""},
+		{"bare parent domain", "https://example.com", ""},
+		{"suffix lookalike", "https://notexample.com", ""},
+		{"exact with other port", "https://app.test:8443", ""},
+		{"wildcard with port", "https://app.example.com:3000", "https://app.example.com:3000"},
+		{"pinned port", "https://app.example.org:3000", "https://app.example.org:3000"},
+		{"pinned port mismatch", "https://app.example.org:4000", ""},
+		{"pinned port missing", "https://app.example.org", ""},
+	} {
+		rec := serve(h, "GET", "/", "", "Origin", tc.origin)
+		if got := rec.Header().Get("Access-Control-Allow-Origin"); got != tc.want {
+			t.Errorf("%s (%s): Access-Control-Allow-Origin = %q, want %q", tc.name, tc.origin, got, tc.want)
+		}
+		if tc.want == "" && len(rec.Header().Values("Access-Control-Allow-Credentials")) > 0 {
+			t.Errorf("%s: CORS headers sent for a disallowed origin", tc.name)
+		}
+	}
+}
+
+func TestCorsPreflightAndCredentials(t *testing.T) {
+	preflight := []string{"Origin", "https://spa.test", "Access-Control-Request-Method", "POST"}
+
+	rec := serve(cors([]string{"*"}, nil, false)(okHandler), "OPTIONS", "/articles", "", preflight...)
+	if rec.Code != http.StatusNoContent || rec.Header().Get("Access-Control-Allow-Origin") != "*" {
+		t.Errorf("wildcard preflight: got %d, Allow-Origin %q", rec.Code, rec.Header().Get("Access-Control-Allow-Origin"))
+	}
+	if rec.Header().Get("Access-Control-Allow-Methods") == "" || rec.Header().Get("Access-Control-Allow-Headers") == "" {
+		t.Errorf("preflight is missing Allow-Methods or Allow-Headers: %v", rec.Header())
+	}
+
+	rec = serve(cors([]string{"https://spa.test"}, nil, true)(okHandler), "OPTIONS", "/articles", "", preflight...)
+	if got := rec.Header().Get("Access-Control-Allow-Origin"); got != "https://spa.test" {
+		t.Errorf("credentials mode must reflect the origin, got %q", got)
+	}
+	if rec.Header().Get("Access-Control-Allow-Credentials") != "true" {
+		t.Error("credentials mode did not send Access-Control-Allow-Credentials")
+	}
+	if !strings.Contains(rec.Header().Get("Access-Control-Allow-Headers"), "X-Tenant-ID") {
+		t.Errorf("preflight does not allow X-Tenant-ID: %q", rec.Header().Get("Access-Control-Allow-Headers"))
+	}
+	rec = serve(cors([]string{"https://spa.test"}, nil, true)(okHandler), "GET", "/articles", "", "Origin", "https://spa.test")
+	if !strings.Contains(rec.Header().Get("Access-Control-Expose-Headers"), "X-RateLimit-Scope") {
+		t.Errorf("X-RateLimit-Scope is not exposed: %q", rec.Header().Get("Access-Control-Expose-Headers"))
+	}
+
+	rec = serve(cors([]string{"*"}, nil, true)(okHandler), "OPTIONS", "/articles", "", preflight...)
+	if rec.Header().Get("Access-Control-Allow-Origin") != "*" || rec.Header().Get("Access-Control-Allow-Credentials") != "" {
+		t.Errorf("a wildcard must never grant credentials: %v", rec.Header())
+	}
+}
+
+func TestNegotiateEncoding(t *testing.T) {
+	for acceptEncoding, want := range map[string]string{
+		"br, gzip":             "br",
+		"gzip, br":             "br",
+		"gzip;q=1, br;q=0.5":   "gzip",
+		"br;q=0.9, gzip;q=0.1": "br",
+		"gzip":                 "gzip",
+		"br;q=0, gzip":         "gzip",
+		"*":                    "br",
+		"identity":             "identity",
+		"":                     "identity",
+		"gzip;q=0, br;q=0":     "identity",
+	} {
+		if got := negotiateEncoding(acceptEncoding); got != want {
+			t.Errorf("negotiateEncoding(%q) = %q, want %q", acceptEncoding, got, want)
+		}
+	}
+}
+
+func TestCompress(t *testing.T) {
+	large := strings.Repeat("article content ", 200)
+	h := compress(1024)(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "text/plain")
+		io.WriteString(w, r.URL.Query().Get("size"))
+		if r.URL.Query().Get("size") == "large" {
+			io.WriteString(w, large)
+		}
+	}))
+	readers := map[string]func(io.Reader) (io.Reader, error){
+		"br":   func(r io.Reader) (io.Reader, error) { return brotli.NewReader(r), nil },
+		"gzip": func(r io.Reader) (io.Reader, error) { return gzip.NewReader(r) },
+	}
+	for _, tc := range []struct {
+		acceptEncoding, want string
+	}{
+		{"br;q=1, gzip;q=0.5", "br"},
+		{"gzip;q=1, br;q=0.5", "gzip"},
+		{"identity", ""},
+	} {
+		rec := serve(h, "GET", "/?size=large", "", "Accept-Encoding", tc.acceptEncoding)
+		if got := rec.Header().Get("Content-Encoding"); got != tc.want {
+			t.Errorf("%q: Content-Encoding = %q, want %q", tc.acceptEncoding, got, tc.want)
+			continue
+		}
+		var body io.Reader = rec.Body
+		if newReader, ok := readers[tc.want]; ok {
+			var err error
+			if body, err = newReader(rec.Body); err != nil {
+				t.Fatal(err)
+			}
+		}
+		if decoded, err := io.ReadAll(body); err != nil || string(decoded) != "large"+large {
+			t.Errorf("%q: body did not round-trip (%v)", tc.acceptEncoding, err)
+		}
+	}
+
+	rec := serve(h, "GET", "/?size=small", "", "Accept-Encoding", "gzip")
+	if rec.Header().Get("Content-Encoding") != "" || rec.Body.String() != "small" || rec.Header().Get("Content-Length") != "5" {
+		t.Errorf("small response should be sent as is with its length, got %v %q", rec.Header(), rec.Body)
+	}
+}
+
+func TestCompressUncompressibleResponses(t *testing.T) {
+	h := testHandler(t, "COMPRESS_MIN_SIZE", "1")
+	withArticles(t, Article{Id: "1", Title: "Digits", Content: "0123456789"})
+
+	for _, tc := range []struct {
+		rangeHeader string
+		want        int
+	}{
+		{"bytes=0-3", http.StatusPartialContent},
+		{"bytes=50-60", http.StatusRequestedRangeNotSatisfiable},
+	} {
+		rec := serve(h, "GET", "/articles/1/content", "", "Range", tc.rangeHeader, "Accept-Encoding", "gzip")
+		if rec.Code != tc.want || rec.Header().Get("Content-Encoding") != "" {
+			t.Errorf("%s: got %d, Content-Encoding %q, want %d uncompressed", tc.rangeHeader, rec.Code, rec.Header().Get("Content-Encoding"), tc.want)
+		}
+		if length := rec.Header().Get("Content-Length"); rec.Body.Len() == 0 || (length != "" && length != strconv.Itoa(rec.Body.Len())) {
+			t.Errorf("%s: Content-Length %q for a %d-byte body %q", tc.rangeHeader, length, rec.Body.Len(), rec.Body)
+		}
+	}
+}
+
+func TestBasicAuth(t *testing.T) {
+	hash, err := bcrypt.GenerateFromPassword([]byte("s3cret"), bcrypt.MinCost)
+	if err != nil {
+		t.Fatal(err)
+	}
+	h := basicAuth("admin", string(hash))(okHandler)
+	for _, tc := range []struct {
+		name, user, pass string
+		want             int
+	}{
+		{"correct", "admin", "s3cret", http.StatusOK},
+		{"wrong password", "admin", "guess", http.StatusUnauthorized},
+		{"wrong user", "root", "s3cret", http.StatusUnauthorized},
+		{"missing", "", "", http.StatusUnauthorized},
+	} {
+		req := httptest.NewRequest("POST", "/articles", nil)
+		if tc.user != "" {
+			req.SetBasicAuth(tc.user, tc.pass)
+		}
+		rec := httptest.NewRecorder()
+		h.ServeHTTP(rec, req)
+		if rec.Code != tc.want {
+			t.Errorf("%s: got %d, want %d", tc.name, rec.Code, tc.want)
+		}
+		if tc.want == http.StatusUnauthorized && rec.Header().Get("WWW-Authenticate") == "" {
+			t.Errorf("%s: 401 without WWW-Authenticate", tc.name)
+		}
+	}
+}
+
+// TestBasicAuthWithJWT re-runs itself in a child process, since the
+// conflicting configuration is rejected with log.Fatalf.
+func TestBasicAuthWithJWT(t *testing.T) {
+	if os.Getenv("AUTH_CONFLICT_CHILD") == "1" {
+		newHandler()
+		return
+	}
+	cmd := exec.Command(os.Args[0], "-test.run=^TestBasicAuthWithJWT$")
+	cmd.Env = append(os.Environ(), "AUTH_CONFLICT_CHILD=1", "BASIC_AUTH_USER=admin", "JWT_SECRET=secret")
+	output, err := cmd.CombinedOutput()
+	if err == nil || !strings.Contains(string(output), "cannot both be set") {
+		t.Errorf("startup with both schemes should fail, got %v: %s", err, output)
+	}
+}
+
+func TestJWTAuth(t *testing.T) {
+	secret := []byte("s3cret")
+	rsaKey, err := rsa.GenerateKey(rand.Reader, 2048)
+	if err != nil {
+		t.Fatal(err)
+	}
+	sign := func(method jwt.SigningMethod, key interface{}, claims jwt.MapClaims) string {
+		t.Helper()
+		token, err := jwt.NewWithClaims(method, claims).SignedString(key)
+		if err != nil {
+			t.Fatal(err)
+		}
+		return token
+	}
+	valid := jwt.MapClaims{"sub": "alice", "exp": time.Now().Add(time.Hour).Unix()}
+
+	h := testHandler(t, "JWT_SECRET", string(secret))
+	withArticles(t)
+	for _, tc := range []struct {
+		name, authorization string
+		want                int
+	}{
+		{"valid", "Bearer " + sign(jwt.SigningMethodHS256, secret, valid), http.StatusCreated},
+		{"missing", "", http.StatusUnauthorized},
+		{"not bearer", "Basic YWRtaW46czNjcmV0", http.StatusUnauthorized},
+		{"bad signature", "Bearer " + sign(jwt.SigningMethodHS256, []byte("guess"), valid), http.StatusUnauthorized},
+		{"alg none", "Bearer " + sign(jwt.SigningMethodNone, jwt.UnsafeAllowNoneSignatureType, valid), http.StatusUnauthorized},
+		{"alg RS256", "Bearer " + sign(jwt.SigningMethodRS256, rsaKey, valid), http.StatusUnauthorized},
+		{"expired", "Bearer " + sign(jwt.SigningMethodHS256, secret, jwt.MapClaims{"sub": "alice", "exp": time.Now().Add(-time.Minute).Unix()}), http.StatusUnauthorized},
+	} {
+		body := `{"id":"` + tc.name + `","title":"` + tc.name + `","content":"c"}`
+		rec := serve(h, "POST", "/articles", body, "Authorization", tc.authorization)
+		if rec.Code != tc.want {
+			t.Errorf("%s: got %d %s, want %d", tc.name, rec.Code, rec.Body, tc.want)
+		}
+		if tc.want == http.StatusUnauthorized && !strings.HasPrefix(rec.Header().Get("WWW-Authenticate"), "Bearer") {
+			t.Errorf("%s: 401 without a Bearer challenge", tc.name)
+		}
+	}
+	if rec := serve(h, "GET", "/articles", ""); rec.Code != http.StatusOK {
+		t.Errorf("reads should not need a token, got %d", rec.Code)
+	}
+
+	var subject interface{}
+	claimed := jwtAuth(string(secret))(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		subject = requestClaims(r)["sub"]
+	}))
+	serve(claimed, "POST", "/", "", "Authorization", "Bearer "+sign(jwt.SigningMethodHS256, secret, valid))
+	if subject != "alice" {
+		t.Errorf("handler saw subject %v, want alice", subject)
+	}
+}
+
+func TestAllowedMethods(t *testing.T) {
+	h := testHandler(t, "ALLOWED_METHODS", "get,HEAD")
+	withArticles(t, sampleArticles...)
+
+	rec := serve(h, "DELETE", "/articles/1", "")
+	if rec.Code != http.StatusMethodNotAllowed || rec.Header().Get("Allow") != "GET, HEAD" {
+		t.Errorf("DELETE on a registered route: got %d, Allow %q", rec.Code, rec.Header().Get("Allow"))
+	}
+	if len(Articles) != len(sampleArticles) {
+		t.Error("the blocked DELETE reached the handler")
+	}
+	if rec := serve(h, "GET", "/articles/1", ""); rec.Code != http.StatusOK {
+		t.Errorf("GET: got %d, want 200", rec.Code)
+	}
+
+	methods := []string{"get", "head"}
+	allowMethods(methods)
+	if methods[0] != "get" || methods[1] != "head" {
+		t.Errorf("allowMethods modified its argument: %v", methods)
+	}
+}
+
+func TestRateLimitTiers(t *testing.T) {
+	request := func(h http.Handler, ip string) *httptest.ResponseRecorder {
+		req := httptest.NewRequest("GET", "/", nil)
+		req.RemoteAddr = ip + ":1234"
+		rec := httptest.NewRecorder()
+		h.ServeHTTP(rec, req)
+		return rec
+	}
+	for _, tc := range []struct {
+		scope   string
+		limiter *rateLimiter
+		// secondIP is the client whose request should trip the limit.
+		secondIP string
+	}{
+		{"ip", newRateLimiter(1, 1, 0, 0, false), "10.0.0.1"},
+		{"global", newRateLimiter(0, 0, 1, 1, false), "10.0.0.2"},
+	} {
+		h := tc.limiter.middleware(okHandler)
+		if rec := request(h, "10.0.0.1"); rec.Code != http.StatusOK {
+			t.Fatalf("%s: first request got %d", tc.scope, rec.Code)
+		}
+		rec := request(h, tc.secondIP)
+		if rec.Code != http.StatusTooManyRequests {
+			t.Fatalf("%s: saturated tier got %d, want 429", tc.scope, rec.Code)
+		}
+		if got := rec.Header().Get("X-RateLimit-Scope"); got != tc.scope {
+			t.Errorf("%s: X-RateLimit-Scope = %q", tc.scope, got)
+		}
+		if rec.Header().Get("Retry-After") == "" {
+			t.Errorf("%s: 429 without Retry-After", tc.scope)
+		}
+	}
+
+	perIP := newRateLimiter(1, 1, 0, 0, false).middleware(okHandler)
+	request(perIP, "10.0.0.1")
+	if rec := request(perIP, "10.0.0.2"); rec.Code != http.StatusOK {
+		t.Errorf("a second IP should get its own bucket, got %d", rec.Code)
+	}
+
+	both := newRateLimiter(1, 1, 1, 1, false)
+	h := both.middleware(okHandler)
+	request(h, "10.0.0.1")
+	if rec := request(h, "10.0.0.2"); rec.Header().Get("X-RateLimit-Scope") != "global" {
+		t.Fatalf("expected the global tier to trip, got %d %v", rec.Code, rec.Header())
+	}
+	both.global = rate.NewLimiter(rate.Inf, 1)
+	if rec := request(h, "10.0.0.2"); rec.Code != http.StatusOK {
+		t.Errorf("a request refused by the global tier used up the client's token, got %d", rec.Code)
+	}
+}
+
+func TestTenantIsolation(t *testing.T) {
+	h := testHandler(t, "MULTI_TENANT", "true", "TENANTS", "acme,globex")
+	withArticles(t)
+
+	if rec := serve(h, "POST", "/articles", `{"id":"1","title":"Acme news","content":"c"}`, "X-Tenant-ID", "acme"); rec.Code != http.StatusCreated {
+		t.Fatalf("create for acme: got %d %s", rec.Code, rec.Body)
+	}
+	if rec := serve(h, "POST", "/articles", `{"id":"1","title":"Globex news","content":"c"}`, "X-Tenant-ID", "globex"); rec.Code != http.StatusCreated {
+		t.Fatalf("create for globex: got %d %s", rec.Code, rec.Body)
+	}
+
+	var acme Article
+	decode(t, serve(h, "GET", "/articles/1", "", "X-Tenant-ID", "acme"), &acme)
+	if acme.Title != "Acme news" {
+		t.Errorf("acme sees %q", acme.Title)
+	}
+	var page struct {
+		Data []Article `json:"data"`
+	}
+	decode(t, serve(h, "GET", "/articles", "", "X-Tenant-ID", "globex"), &page)
+	if len(page.Data) != 1 || page.Data[0].Title != "Globex news" {
+		t.Errorf("globex lists %+v", page.Data)
+	}
+
+	if rec := serve(h, "DELETE", "/articles/1", "", "X-Tenant-ID", "globex"); rec.Code != http.StatusNoContent {
+		t.Fatalf("delete for globex: got %d", rec.Code)
+	}
+	if rec := serve(h, "GET", "/articles/1", "", "X-Tenant-ID", "acme"); rec.Code != http.StatusOK {
+		t.Errorf("globex's delete removed acme's article, got %d", rec.Code)
+	}
+
+	for _, tc := range []struct {
+		tenant string
+		want   int
+	}{
+		{"", http.StatusBadRequest},
+		{"not a tenant!", http.StatusBadRequest},
+		{"initech", http.StatusForbidden},
+	} {
+		rec := serve(h, "GET", "/articles", "", "X-Tenant-ID", tc.tenant)
+		var body CustomError
+		if rec.Code != tc.want || json.Unmarshal(rec.Body.Bytes(), &body) != nil || body.Message == "" {
+			t.Errorf("tenant %q: got %d %s, want %d", tc.tenant, rec.Code, rec.Body, tc.want)
+		}
+	}
+	if rec := serve(h, "GET", "/health", ""); rec.Code != http.StatusOK {
+		t.Errorf("non-article routes should not need a tenant, got %d", rec.Code)
+	}
+}
@@ -0,0 +1,160 @@
+package main
+
+import (
+	"context"
+	"math"
+	"net"
+	"net/http"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"golang.org/x/time/rate"
+)
+
+// Per-IP buckets unused for clientIdleTTL are dropped by sweepIdle, which
+// checks every clientSweepInterval. A dropped client simply starts over
+// with a full bucket.
+const (
+	clientIdleTTL       = 3 * time.Minute
+	clientSweepInterval = time.Minute
+)
+
+type clientBucket struct {
+	limiter  *rate.Limiter
+	lastSeen time.Time
+}
+
+// rateLimiter enforces two independent token-bucket tiers: one bucket per
+// client IP and one global bucket shared by every client. A zero rate
+// disables that tier.
+type rateLimiter struct {
+	mu      sync.Mutex
+	clients map[string]*clientBucket
+	ipRate  rate.Limit
+	ipBurst int
+	global  *rate.Limiter
+	// trustProxy makes clientIP use X-Forwarded-For. Only enable it behind
+	// a proxy that sets the header, or clients can pick their own bucket.
+	trustProxy bool
+}
+
+func newRateLimiter(ipRPS float64, ipBurst int, globalRPS float64, globalBurst int, trustProxy bool) *rateLimiter {
+	rl := &rateLimiter{
+		clients:    make(map[string]*clientBucket),
+		ipRate:     rate.Limit(ipRPS),
+		ipBurst:    ipBurst,
+		trustProxy: trustProxy,
+	}
+	if globalRPS > 0 {
+		rl.global = rate.NewLimiter(rate.Limit(globalRPS), globalBurst)
+	}
+	return rl
+}
+
+func (rl *rateLimiter) clientLimiter(ip string) *rate.Limiter {
+	rl.mu.Lock()
+	defer rl.mu.Unlock()
+	bucket, ok := rl.clients[ip]
+	if !ok {
+		bucket = &clientBucket{limiter: rate.NewLimiter(rl.ipRate, rl.ipBurst)}
+		rl.clients[ip] = bucket
+	}
+	bucket.lastSeen = time.Now()
+	return bucket.limiter
+}
+
+// sweepIdle drops client buckets idle for longer than clientIdleTTL until
+// shutdown, so the map does not grow with every IP ever seen.
+func (rl *rateLimiter) sweepIdle(ctx context.Context, draining <-chan struct{}) {
+	ticker := time.NewTicker(clientSweepInterval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-draining:
+			return
+		case now := <-ticker.C:
+			rl.mu.Lock()
+			for ip, bucket := range rl.clients {
+				if now.Sub(bucket.lastSeen) > clientIdleTTL {
+					delete(rl.clients, ip)
+				}
+			}
+			rl.mu.Unlock()
+		}
+	}
+}
+
+// clientIP returns the address the per-IP tier is keyed on. With trustProxy
+// it is the last X-Forwarded-For entry, the one appended by our own proxy;
+// earlier entries are client-supplied and cannot be trusted.
+func (rl *rateLimiter) clientIP(r *http.Request) string {
+	if rl.trustProxy {
+		if forwarded := r.Header.Values("X-Forwarded-For"); len(forwarded) > 0 {
+			hops := strings.Split(forwarded[len(forwarded)-1], ",")
+			if ip := strings.TrimSpace(hops[len(hops)-1]); ip != "" {
+				return ip
+			}
+		}
+	}
+	host, _, err := net.SplitHostPort(r.RemoteAddr)
+	if err != nil {
+		return r.RemoteAddr
+	}
+	return host
+}
+
+// reserve takes a token from limiter at now. It returns how long until one
+// is available, or 0 and the reservation when a token was taken, so a later
+// tier that rejects the request can hand it back with CancelAt(now). The
+// same now must be used: Cancel at a later instant restores nothing.
+func reserve(limiter *rate.Limiter, now time.Time) (time.Duration, *rate.Reservation) {
+	reservation := limiter.ReserveN(now, 1)
+	if !reservation.OK() {
+		return time.Duration(math.MaxInt64), nil
+	}
+	if delay := reservation.DelayFrom(now); delay > 0 {
+		reservation.CancelAt(now)
+		return delay, nil
+	}
+	return 0, reservation
+}
+
+func tooManyRequests(w http.ResponseWriter, r *http.Request, scope string, wait time.Duration) {
+	w.Header().Set("X-RateLimit-Scope", scope)
+	w.Header().Set("Retry-After", strconv.Itoa(int(math.Ceil(min(wait, time.Hour).Seconds()))))
+	writeError(w, r, http.StatusTooManyRequests, "Rate limit exceeded")
+}
+
+// middleware rejects requests over either tier with 429 and a Retry-After
+// in whole seconds. X-RateLimit-Scope tells the client whether its own
+// ("ip") or the shared ("global") limit tripped.
+func (rl *rateLimiter) middleware(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		now := time.Now()
+		var ipReservation *rate.Reservation
+		if rl.ipRate > 0 {
+			wait, reservation := reserve(rl.clientLimiter(rl.clientIP(r)), now)
+			if wait > 0 {
+				tooManyRequests(w, r, "ip", wait)
+				return
+			}
+			ipReservation = reservation
+		}
+		if rl.global != nil {
+			if wait, _ := reserve(rl.global, now); wait > 0 {
+				// A request the global tier refuses must not use up the
+				// client's own allowance as well.
+				if ipReservation != nil {
+					ipReservation.CancelAt(now)
+				}
+				tooManyRequests(w, r, "global", wait)
+				return
+			}
+		}
+		next.ServeHTTP(w, r)
+	})
+}
@@ -0,0 +1,35 @@
+package main
+
+import (
+	"context"
+	"net/http"
+	"regexp"
+
+	"github.com/google/uuid"
+)
+
+const requestIdContextKey contextKey = "request_id"
+
+// Incoming ids end up in logs and response bodies, so anything that is not
+// a short token is replaced rather than trusted.
+var requestIdPattern = regexp.MustCompile(`^[A-Za-z0-9._:-]{1,128}$`)
+
+// withRequestId tags every request with the caller's X-Request-ID, or a
+// fresh UUID when it is missing or malformed, stores it in the request
+// context and echoes it back in the X-Request-ID response header.
+func withRequestId(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		id := r.Header.Get("X-Request-ID")
+		if !requestIdPattern.MatchString(id) {
+			id = uuid.NewString()
+		}
+		w.Header().Set("X-Request-ID", id)
+		next.ServeHTTP(w, r.WithContext(context.WithValue(r.Context(), requestIdContextKey, id)))
+	})
+}
+
+// requestId returns the id withRequestId assigned to r, or "" outside it.
+func requestId(r *http.Request) string {
+	id, _ := r.Context().Value(requestIdContextKey).(string)
+	return id
+}
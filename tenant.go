@@ -0,0 +1,62 @@
+package main
+
+import (
+	"context"
+	"net/http"
+	"regexp"
+	"strings"
+)
+
+type contextKey string
+
+const tenantContextKey contextKey = "tenant"
+
+var tenantIdPattern = regexp.MustCompile(`^[A-Za-z0-9_-]{1,64}$`)
+
+// tenantScope requires an X-Tenant-ID header on every /articles request and
+// stores it in the request context for the handlers to scope by. When
+// allowedTenants is non-empty, tenants outside it are refused with 403.
+func tenantScope(allowedTenants []string) func(http.Handler) http.Handler {
+	allowed := make(map[string]bool, len(allowedTenants))
+	for _, tenant := range allowedTenants {
+		allowed[tenant] = true
+	}
+	return func(next http.Handler) http.Handler {
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			if !strings.HasPrefix(r.URL.Path, "/articles") {
+				next.ServeHTTP(w, r)
+				return
+			}
+			tenant := r.Header.Get("X-Tenant-ID")
+			if !tenantIdPattern.MatchString(tenant) {
+				writeError(w, r, http.StatusBadRequest, "Missing or invalid X-Tenant-ID header")
+				return
+			}
+			if len(allowed) > 0 && !allowed[tenant] {
+				writeError(w, r, http.StatusForbidden, "Unknown tenant")
+				return
+			}
+			next.ServeHTTP(w, r.WithContext(context.WithValue(r.Context(), tenantContextKey, tenant)))
+		})
+	}
+}
+
+// tenantId returns the request's tenant, or "" when multi-tenant mode is off.
+func tenantId(r *http.Request) string {
+	tenant, _ := r.Context().Value(tenantContextKey).(string)
+	return tenant
+}
+
+// tenantArticles returns the articles visible to the request's tenant.
+func tenantArticles(r *http.Request) []Article {
+	tenant := tenantId(r)
+	articles := []Article{}
+	articlesMu.RLock()
+	defer articlesMu.RUnlock()
+	for _, article := range Articles {
+		if article.TenantId == tenant {
+			articles = append(articles, article)
+		}
+	}
+	return articles
+}
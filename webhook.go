@@ -0,0 +1,133 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"log"
+	"net/http"
+	"sync/atomic"
+	"time"
+)
+
+const (
+	webhookMaxAttempts    = 5
+	webhookInitialBackoff = 500 * time.Millisecond
+	// webhookWorkers bounds concurrent deliveries; webhookQueueSize leaves
+	// room for a full batch request to be queued behind them.
+	webhookWorkers   = 4
+	webhookQueueSize = 1024
+)
+
+var (
+	webhookURL    string
+	webhookClient = &http.Client{Timeout: 5 * time.Second}
+	webhookQueue  = make(chan WebhookEvent, webhookQueueSize)
+	// webhookDeadLetters counts events that were never delivered: every attempt
+	// failed or the queue was full.
+	webhookDeadLetters atomic.Int64
+)
+
+type WebhookEvent struct {
+	Type      string    `json:"type"`
+	Tenant    string    `json:"tenant,omitempty"`
+	Article   Article   `json:"article"`
+	Timestamp time.Time `json:"timestamp"`
+}
+
+// notifyWebhook queues the event for the webhook workers so the API response
+// never waits on the webhook target. When the queue is full the event is
+// dead-lettered rather than blocking the request. It is a no-op without
+// WEBHOOK_URL.
+func notifyWebhook(eventType string, article Article) {
+	if webhookURL == "" {
+		return
+	}
+	event := WebhookEvent{Type: eventType, Tenant: article.TenantId, Article: article, Timestamp: time.Now().UTC()}
+	select {
+	case webhookQueue <- event:
+	default:
+		webhookDeadLetters.Add(1)
+		log.Printf("webhook %s: queue full, dead-lettered", event.Type)
+	}
+}
+
+// startWebhookWorkers starts the fixed pool draining webhookQueue. It is a
+// no-op without WEBHOOK_URL.
+func startWebhookWorkers() {
+	if webhookURL == "" {
+		return
+	}
+	for i := 0; i < webhookWorkers; i++ {
+		background.run(webhookWorker)
+	}
+}
+
+// webhookWorker delivers queued events until shutdown. Once draining is
+// closed it delivers whatever is still queued and returns.
+func webhookWorker(ctx context.Context, draining <-chan struct{}) {
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-draining:
+			for {
+				select {
+				case event := <-webhookQueue:
+					deliverWebhook(ctx, event)
+				default:
+					return
+				}
+			}
+		case event := <-webhookQueue:
+			deliverWebhook(ctx, event)
+		}
+	}
+}
+
+func deliverWebhook(ctx context.Context, event WebhookEvent) {
+	payload, err := json.Marshal(event)
+	if err != nil {
+		log.Printf("webhook %s: encoding event: %v", event.Type, err)
+		webhookDeadLetters.Add(1)
+		return
+	}
+	backoff := webhookInitialBackoff
+	for attempt := 1; attempt <= webhookMaxAttempts; attempt++ {
+		if err = postWebhook(ctx, payload); err == nil {
+			return
+		}
+		log.Printf("webhook %s: attempt %d/%d failed: %v", event.Type, attempt, webhookMaxAttempts, err)
+		if attempt == webhookMaxAttempts {
+			break
+		}
+		select {
+		case <-ctx.Done():
+			webhookDeadLetters.Add(1)
+			log.Printf("webhook %s: dead-lettered, shutting down", event.Type)
+			return
+		case <-time.After(backoff):
+			backoff *= 2
+		}
+	}
+	webhookDeadLetters.Add(1)
+	log.Printf("webhook %s: dead-lettered after %d attempts", event.Type, webhookMaxAttempts)
+}
+
+func postWebhook(ctx context.Context, payload []byte) error {
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, webhookURL, bytes.NewReader(payload))
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Content-Type", "application/json")
+	resp, err := webhookClient.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode < 200 || resp.StatusCode > 299 {
+		return fmt.Errorf("unexpected status %d", resp.StatusCode)
+	}
+	return nil
+}
@@ -0,0 +1,114 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+// startTestWebhook points the webhook at handler and runs one worker on a
+// fresh queue until the test ends.
+func startTestWebhook(t *testing.T, handler http.HandlerFunc) {
+	t.Helper()
+	target := httptest.NewServer(handler)
+	setConfig(t, &webhookURL, target.URL)
+	setConfig(t, &webhookQueue, make(chan WebhookEvent, 8))
+	workers := newBackgroundGroup()
+	workers.run(webhookWorker)
+	t.Cleanup(func() {
+		workers.shutdown(time.Second)
+		target.Close()
+	})
+}
+
+func TestWebhookRetriesUntilDelivered(t *testing.T) {
+	var attempts atomic.Int32
+	target := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if attempts.Add(1) == 1 {
+			w.WriteHeader(http.StatusServiceUnavailable)
+		}
+	}))
+	defer target.Close()
+	setConfig(t, &webhookURL, target.URL)
+	deadLetters := webhookDeadLetters.Load()
+
+	deliverWebhook(context.Background(), WebhookEvent{Type: "article.updated", Article: sampleArticles[0]})
+	if got := attempts.Load(); got != 2 {
+		t.Errorf("target saw %d attempts, want a failure and a retry", got)
+	}
+	if webhookDeadLetters.Load() != deadLetters {
+		t.Error("a delivered event was dead-lettered")
+	}
+}
+
+func TestWebhookSentWithoutBlockingResponse(t *testing.T) {
+	received, release := make(chan WebhookEvent, 1), make(chan struct{})
+	startTestWebhook(t, func(w http.ResponseWriter, r *http.Request) {
+		var event WebhookEvent
+		if err := json.NewDecoder(r.Body).Decode(&event); err != nil {
+			t.Errorf("decoding event: %v", err)
+		}
+		received <- event
+		<-release
+	})
+	defer close(release)
+
+	h := testHandler(t)
+	withArticles(t)
+	// The target holds every delivery open, so the request only completes
+	// because delivery happens off the request path.
+	if rec := serve(h, "POST", "/articles", `{"id":"1","title":"Hello","content":"c"}`); rec.Code != http.StatusCreated {
+		t.Fatalf("create: got %d", rec.Code)
+	}
+	select {
+	case event := <-received:
+		if event.Type != "article.created" || event.Article.Id != "1" || event.Timestamp.IsZero() {
+			t.Errorf("unexpected event %+v", event)
+		}
+	case <-time.After(5 * time.Second):
+		t.Fatal("webhook was not delivered")
+	}
+}
+
+func TestWebhookQueueFull(t *testing.T) {
+	setConfig(t, &webhookURL, "http://webhook.invalid")
+	setConfig(t, &webhookQueue, make(chan WebhookEvent, 1))
+	deadLetters := webhookDeadLetters.Load()
+
+	notifyWebhook("article.created", sampleArticles[0])
+	notifyWebhook("article.created", sampleArticles[1])
+	if got := webhookDeadLetters.Load() - deadLetters; got != 1 {
+		t.Errorf("dead letters grew by %d, want 1 for the event that did not fit", got)
+	}
+	if len(webhookQueue) != 1 {
+		t.Errorf("queue holds %d events, want 1", len(webhookQueue))
+	}
+}
+
+func TestWebhookQueueDrainsOnShutdown(t *testing.T) {
+	var delivered atomic.Int32
+	target := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		delivered.Add(1)
+	}))
+	defer target.Close()
+	setConfig(t, &webhookURL, target.URL)
+	setConfig(t, &webhookQueue, make(chan WebhookEvent, 8))
+	deadLetters := webhookDeadLetters.Load()
+	for _, article := range sampleArticles {
+		notifyWebhook("article.created", article)
+	}
+
+	workers := newBackgroundGroup()
+	workers.run(webhookWorker)
+	workers.shutdown(5 * time.Second)
+	if got := delivered.Load(); got != int32(len(sampleArticles)) {
+		t.Errorf("delivered %d of %d queued events before exiting", got, len(sampleArticles))
+	}
+	if webhookDeadLetters.Load() != deadLetters || len(webhookQueue) != 0 {
+		t.Error("queued events were dropped on shutdown")
+	}
+}